@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsimple"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+	"github.com/michaelmosher/monitoring/pkg/metricly"
+	metricly_http "github.com/michaelmosher/monitoring/pkg/metricly/http"
+	"github.com/michaelmosher/monitoring/pkg/notify"
+	"github.com/michaelmosher/monitoring/pkg/octopus"
+	octopus_http "github.com/michaelmosher/monitoring/pkg/octopus/http"
+)
+
+type httpDoer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+type octopusCredentials struct {
+	Label       string   `hcl:",label"`
+	InstanceURL string   `hcl:"instanceURL"`
+	APIKey      string   `hcl:"apiKey"`
+	Space       string   `hcl:"space"`
+	DisplayName string   `hcl:"displayName,optional"`
+	Checks      []string `hcl:"checks,optional"`
+	CDCProjects []string `hcl:"cdcProjects,optional"`
+	HubElement  string   `hcl:"hubElement,optional"`
+	SkipInvalid bool     `hcl:"skipInvalidRecords,optional"`
+}
+
+type octopusConfig struct {
+	Credentials []octopusCredentials `hcl:"credentials,block"`
+	CDCProjects []string             `hcl:"cdcProjects"`
+	CacheTTL    string               `hcl:"cacheTTL,optional"`
+	Extra       hcl.Body             `hcl:",remain"`
+}
+
+// metriclyConfig authenticates with either an API key or a username and
+// password, against either a region or an explicit URL (e.g. a proxy).
+type metriclyConfig struct {
+	Username string `hcl:"Username,optional"`
+	Password string `hcl:"Password,optional"`
+	APIKey   string `hcl:"apiKey,optional"`
+	Region   string `hcl:"region,optional"`
+	URL      string `hcl:"url,optional"`
+}
+
+type retryConfig struct {
+	MaxAttempts int      `hcl:"maxAttempts,optional"`
+	BaseDelay   string   `hcl:"baseDelay,optional"`
+	MaxDelay    string   `hcl:"maxDelay,optional"`
+	Methods     []string `hcl:"methods,optional"`
+}
+
+type thresholdsConfig struct {
+	Name     string `hcl:",label"`
+	Warning  string `hcl:"warning,optional"`
+	Critical string `hcl:"critical"`
+}
+
+// cdcConfig mirrors cdc.Config. Durations are strings such as "10m".
+type cdcConfig struct {
+	HubElement    string             `hcl:"hubElement,optional"`
+	LatencyMetric string             `hcl:"latencyMetric,optional"`
+	SampleWindow  string             `hcl:"sampleWindow,optional"`
+	SampleTTL     string             `hcl:"sampleTTL,optional"`
+	BatchSize     int                `hcl:"sampleBatchSize,optional"`
+	Workers       int                `hcl:"sampleWorkers,optional"`
+	OfflineRoles  []string           `hcl:"offlineRoles,optional"`
+	IdleRoles     []string           `hcl:"idleRoles,optional"`
+	DegradedRoles []string           `hcl:"degradedRoles,optional"`
+	MaxBehind     int                `hcl:"maxReleasesBehind,optional"`
+	Warning       string             `hcl:"warning,optional"`
+	Critical      string             `hcl:"critical,optional"`
+	Roles         []thresholdsConfig `hcl:"role,block"`
+	Projects      []thresholdsConfig `hcl:"project,block"`
+
+	SustainedWindow  string `hcl:"sustainedWindow,optional"`
+	SustainedFor     string `hcl:"sustainedFor,optional"`
+	SampleResolution string `hcl:"sampleResolution,optional"`
+	SampleRollup     string `hcl:"sampleRollup,optional"`
+}
+
+type notifyConfig struct {
+	Format     string `hcl:",label"`
+	WebhookURL string `hcl:"webhookURL"`
+	Mode       string `hcl:"mode,optional"`
+}
+
+type stateConfig struct {
+	File string `hcl:"file"`
+}
+
+type historyConfig struct {
+	Dir           string `hcl:"dir"`
+	RetentionDays int    `hcl:"retentionDays,optional"`
+}
+
+type mainConfig struct {
+	Metricly metriclyConfig `hcl:"Metricly,block"`
+	Octopus  octopusConfig  `hcl:"Octopus,block"`
+	Retry    *retryConfig   `hcl:"Retry,block"`
+	CDC      *cdcConfig     `hcl:"CDC,block"`
+	Notify   []notifyConfig `hcl:"notify,block"`
+	State    *stateConfig   `hcl:"State,block"`
+	History  *historyConfig `hcl:"History,block"`
+}
+
+func defaultConfigFile() string {
+	usr, _ := user.Current()
+	return fmt.Sprintf("%s/.monitoring/cdc_status.hcl", usr.HomeDir)
+}
+
+func readConfigFile(configFile string, cfg *mainConfig) {
+	err := hclsimple.DecodeFile(configFile, nil, cfg)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %s", err)
+	}
+}
+
+// newClient builds a Metricly API client from the Metricly block.
+func (c metriclyConfig) newClient(httpClient httpDoer) metricly_http.Service {
+	if c.APIKey == "" && (c.Username == "" || c.Password == "") {
+		log.Fatalf("Invalid Metricly: set either apiKey, or Username and Password")
+	}
+
+	if c.Region != "" && c.URL != "" {
+		log.Fatalf("Invalid Metricly: set either region or url, not both")
+	}
+
+	baseURL := c.URL
+
+	if c.Region != "" {
+		url, err := metricly_http.RegionURL(c.Region)
+		if err != nil {
+			log.Fatalf("Invalid Metricly.region: %s", err)
+		}
+
+		baseURL = url
+	}
+
+	return metricly_http.New(httpClient, baseURL, metricly_http.Credentials{
+		Username: c.Username,
+		Password: c.Password,
+		APIKey:   c.APIKey,
+	})
+}
+
+// toCDCConfig converts the optional CDC block; anything left unset falls back
+// to cdc.DefaultConfig.
+func (c *cdcConfig) toCDCConfig() cdc.Config {
+	if c == nil {
+		return cdc.Config{}
+	}
+
+	cfg := cdc.Config{
+		HubElement:        c.HubElement,
+		LatencyMetric:     c.LatencyMetric,
+		SampleWindow:      parseDuration("CDC.sampleWindow", c.SampleWindow),
+		SampleTTL:         parseDuration("CDC.sampleTTL", c.SampleTTL),
+		SampleBatchSize:   c.BatchSize,
+		SampleWorkers:     c.Workers,
+		OfflineRoles:      c.OfflineRoles,
+		IdleRoles:         c.IdleRoles,
+		DegradedRoles:     c.DegradedRoles,
+		MaxReleasesBehind: c.MaxBehind,
+		Latency: cdc.Thresholds{
+			Warning:  parseDuration("CDC.warning", c.Warning),
+			Critical: parseDuration("CDC.critical", c.Critical),
+		},
+		RoleLatency:    make(map[string]cdc.Thresholds),
+		ProjectLatency: make(map[string]cdc.Thresholds),
+		Sustained: cdc.Sustained{
+			Window:     parseDuration("CDC.sustainedWindow", c.SustainedWindow),
+			For:        parseDuration("CDC.sustainedFor", c.SustainedFor),
+			Resolution: parseDuration("CDC.sampleResolution", c.SampleResolution),
+			Rollup:     parseRollup("CDC.sampleRollup", c.SampleRollup),
+		},
+	}
+
+	for _, r := range c.Roles {
+		cfg.RoleLatency[r.Name] = r.toThresholds("CDC.role." + r.Name)
+	}
+
+	for _, p := range c.Projects {
+		cfg.ProjectLatency[p.Name] = p.toThresholds("CDC.project." + p.Name)
+	}
+
+	return cfg
+}
+
+// defaultOctopusCacheTTL is how long machines, tenants and projects are
+// shared between checks, unless the Octopus block sets cacheTTL.
+const defaultOctopusCacheTTL = time.Minute
+
+// legacyChecks are the checks that ran against the ASI and AOS instances
+// before they could be configured, so that a block written then without
+// checks keeps running just those.
+var legacyChecks = map[string][]cdc.Check{
+	"ASI": {cdc.CheckOfflineNUCs, cdc.CheckIdleMachines},
+	"AOS": {cdc.CheckIdleMachines},
+}
+
+// instances builds a cdc.Instance for every credentials block. Blocks without
+// their own cdcProjects use the Octopus-wide list. Each instance's checks
+// share one cache of its machines, tenants and projects.
+func (c octopusConfig) instances(httpClient httpDoer) []cdc.Instance {
+	instances := make([]cdc.Instance, 0, len(c.Credentials))
+
+	ttl := parseDuration("Octopus.cacheTTL", c.CacheTTL)
+	if c.CacheTTL == "" {
+		ttl = defaultOctopusCacheTTL
+	}
+
+	for _, block := range c.Credentials {
+		projects := block.CDCProjects
+		if len(projects) == 0 {
+			projects = c.CDCProjects
+		}
+
+		checks := legacyChecks[block.Label]
+
+		if len(block.Checks) > 0 {
+			checks = make([]cdc.Check, 0, len(block.Checks))
+		}
+
+		for _, name := range block.Checks {
+			check, err := cdc.ParseCheck(name)
+			if err != nil {
+				log.Fatalf("Invalid Octopus.credentials.%s.checks: %s", block.Label, err)
+			}
+
+			checks = append(checks, check)
+		}
+
+		client := octopus_http.New(httpClient, block.InstanceURL, block.Space, block.APIKey)
+
+		if block.SkipInvalid {
+			label := block.Label
+			client.OnInvalidRecord = func(err error) {
+				log.Printf("Skipping invalid Octopus record from %s: %s", label, err)
+			}
+		}
+
+		instances = append(instances, cdc.Instance{
+			Label:       block.Label,
+			DisplayName: block.DisplayName,
+			Octopus:     octopus.New(octopus.NewCache(client, ttl)),
+			Projects:    projects,
+			HubElement:  block.HubElement,
+			Checks:      checks,
+		})
+	}
+
+	return instances
+}
+
+func newNotifiers(httpClient httpDoer, blocks []notifyConfig) []*notify.Notifier {
+	notifiers := make([]*notify.Notifier, 0, len(blocks))
+
+	for _, block := range blocks {
+		n, err := notify.New(httpClient, block.WebhookURL, notify.Format(block.Format), notify.Mode(block.Mode))
+		if err != nil {
+			log.Fatalf("Invalid notify.%s: %s", block.Format, err)
+		}
+
+		notifiers = append(notifiers, n)
+	}
+
+	return notifiers
+}
+
+func (t thresholdsConfig) toThresholds(name string) cdc.Thresholds {
+	return cdc.Thresholds{
+		Warning:  parseDuration(name+".warning", t.Warning),
+		Critical: parseDuration(name+".critical", t.Critical),
+	}
+}
+
+func parseRollup(name string, value string) metricly.Rollup {
+	rollup := metricly.Rollup(strings.ToUpper(value))
+
+	switch rollup {
+	case "", metricly.RollupAvg, metricly.RollupMax, metricly.RollupMin, metricly.RollupZero:
+		return rollup
+	default:
+		log.Fatalf("Invalid %s: %q is not one of AVG, MAX, MIN or ZERO", name, value)
+		return ""
+	}
+}
+
+func parseDuration(name string, value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %s", name, err)
+	}
+
+	return d
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+	"github.com/michaelmosher/monitoring/pkg/history"
+)
+
+const historyBarWidth = 40
+
+// recordHistory appends report's observations to the configured history
+// store, pruning anything older than the retention period.
+func recordHistory(cfg *historyConfig, report cdc.Report) {
+	if cfg == nil {
+		return
+	}
+
+	store := history.Store{Dir: cfg.Dir}
+
+	if err := store.Append(history.Records(report)); err != nil {
+		log.Printf("Failed to record history: %s", err)
+	}
+
+	if cfg.RetentionDays > 0 {
+		if err := store.Prune(time.Now().AddDate(0, 0, -cfg.RetentionDays)); err != nil {
+			log.Printf("Failed to prune history: %s", err)
+		}
+	}
+}
+
+// showHistory prints a tenant's HVR latency and outages over the last few
+// days, from the history recorded by previous runs.
+func showHistory(args []string) {
+	flags := flag.NewFlagSet("cdc_status history", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile(), "path to the HCL configuration file")
+	tenant := flags.String("tenant", "", "the tenant name to show history for")
+	days := flags.Int("days", 7, "how many days of history to show")
+	flags.Parse(args)
+
+	if *tenant == "" {
+		log.Fatalf("-tenant is required")
+	}
+
+	var config mainConfig
+	readConfigFile(*configFile, &config)
+
+	if config.History == nil {
+		log.Fatalf("No History block in %s, so no history has been recorded", *configFile)
+	}
+
+	records, err := history.Store{Dir: config.History.Dir}.Since(time.Now().AddDate(0, 0, -*days))
+	if err != nil {
+		log.Fatalf("Failed to read history: %s", err)
+	}
+
+	h := history.Summarize(records, *tenant)
+
+	if h.Runs == 0 {
+		fmt.Printf("No history for %s in the last %d days\n", *tenant, *days)
+		return
+	}
+
+	fmt.Printf("%s, last %d days (%d runs):\n", h.Tenant, *days, h.Runs)
+	fmt.Printf("  - UAID: %s\n", strings.Join(h.UAIDs, ", "))
+
+	printLatency(h.Latency)
+	printOutages(h.Outages)
+}
+
+func printLatency(points []history.Point) {
+	if len(points) == 0 {
+		fmt.Println("  - HVR latency: no samples")
+		return
+	}
+
+	var min, max, total time.Duration
+	min = points[0].Latency
+
+	for _, p := range points {
+		total += p.Latency
+
+		if p.Latency < min {
+			min = p.Latency
+		}
+
+		if p.Latency > max {
+			max = p.Latency
+		}
+	}
+
+	avg := total / time.Duration(len(points))
+
+	fmt.Printf("  - HVR latency (minutes): min %.1f, avg %.1f, max %.1f\n", min.Minutes(), avg.Minutes(), max.Minutes())
+
+	for _, p := range points {
+		width := 0
+		if max > 0 {
+			width = int(float64(historyBarWidth) * float64(p.Latency) / float64(max))
+		}
+
+		fmt.Printf("    %s %6.1f %s\n", p.Time.Local().Format("2006-01-02 15:04"), p.Latency.Minutes(), strings.Repeat("#", width))
+	}
+}
+
+func printOutages(outages []history.Outage) {
+	fmt.Printf("  - Outages: %d\n", len(outages))
+
+	for _, o := range outages {
+		end := o.End.Local().Format("2006-01-02 15:04")
+		if o.Ongoing {
+			end += " (ongoing)"
+		}
+
+		fmt.Printf("    - %s Unavailable from %s to %s\n", o.Machine, o.Start.Local().Format("2006-01-02 15:04"), end)
+	}
+}
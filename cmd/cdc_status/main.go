@@ -1,187 +1,302 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
-	"os/user"
+	"os"
+	"os/signal"
+	"strings"
 	"time"
 
-	"github.com/hashicorp/hcl/v2"
-	"github.com/hashicorp/hcl/v2/hclsimple"
-
 	"github.com/michaelmosher/monitoring/pkg/cdc"
-
 	"github.com/michaelmosher/monitoring/pkg/metricly"
-	metricly_http "github.com/michaelmosher/monitoring/pkg/metricly/http"
-	"github.com/michaelmosher/monitoring/pkg/octopus"
-	octopus_http "github.com/michaelmosher/monitoring/pkg/octopus/http"
+	"github.com/michaelmosher/monitoring/pkg/notify"
+	"github.com/michaelmosher/monitoring/pkg/retry"
+	"github.com/michaelmosher/monitoring/pkg/state"
 )
 
-type octopusCredentials struct {
-	Label       string `hcl:",label"`
-	InstanceURL string `hcl:"instanceURL"`
-	APIKey      string `hcl:"apiKey"`
-	Space       string `hcl:"space"`
+// commands are the subcommands of cdc_status. Without one, it prints a
+// single report and exits.
+var commands = map[string]func(args []string){
+	"serve":     serve,
+	"history":   showHistory,
+	"remediate": remediate,
+	"tenant":    showTenant,
 }
 
-type octopusConfig struct {
-	Credentials []octopusCredentials `hcl:"credentials,block"`
-	CDCProjects []string             `hcl:"cdcProjects"`
-	Extra       hcl.Body             `hcl:",remain"`
-}
+func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
 
-type metriclyConfig struct {
-	Username string `hcl:"Username"`
-	Password string `hcl:"Password"`
+	report(os.Args[1:])
 }
 
-type mainConfig struct {
-	Metricly metriclyConfig `hcl:"Metricly,block"`
-	Octopus  octopusConfig  `hcl:"Octopus,block"`
-}
+func report(args []string) {
+	flags := flag.NewFlagSet("cdc_status", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile(), "path to the HCL configuration file")
+	format := flags.String("format", "text", "output format: text, json, csv or markdown")
+	timeout := flags.Duration("timeout", 0, "give up on the whole run after this long (0 means no limit)")
+	flags.Parse(args)
 
-type unhealthyResult struct {
-	name     string
-	duration float64
-}
+	write, ok := writers[*format]
+	if !ok {
+		log.Fatalf("Unknown output format %q", *format)
+	}
 
-func main() {
 	var config mainConfig
-	readConfigFile(&config)
+	readConfigFile(*configFile, &config)
 
-	httpClient := &http.Client{
-		Timeout: 10 * time.Second,
+	ctx, cancel := newRunContext(*timeout)
+	defer cancel()
+
+	httpClient := newHTTPClient(config.Retry)
+	service, instances := newService(config, httpClient)
+	printReport(ctx, cancel, config, httpClient, service, instances, write)
+}
+
+// printReport runs the checks and writes the report, exiting with status 1
+// if any of it is unknown.
+func printReport(ctx context.Context, cancel context.CancelFunc, config mainConfig, httpClient *retry.Doer, service *cdc.Service, instances []cdc.Instance, write writer) {
+	notifiers := newNotifiers(httpClient, config.Notify)
+
+	result := service.Run(ctx, instances...)
+	logHTTPStats(httpClient.Stats())
+	recordHistory(config.History, result)
+	changes := trackState(config.State, result)
+	sections := newSections(result, changes)
+	unknown := unknownSection(sections)
+
+	if recovered := recoveredSection(changes); len(recovered.findings) > 0 {
+		sections = append(sections, recovered)
+	}
+
+	if len(unknown.findings) > 0 {
+		sections = append(sections, unknown)
 	}
 
-	service := cdc.Service{
-		Metricly: metricly.New(
-			metricly_http.Service{
-				HTTPClient: httpClient,
-				Username:   config.Metricly.Username,
-				Password:   config.Metricly.Password,
-			},
-		),
+	if err := write(os.Stdout, sections); err != nil {
+		log.Fatalf("Failed to write report: %s", err)
 	}
 
-	var asiOcto, aosOcto octopus.Service
+	sendNotifications(ctx, notifiers, result, changes)
 
-	for _, block := range config.Octopus.Credentials {
-		if block.Label == "ASI" {
-			asiOcto = octopus.New(
-				octopus_http.New(httpClient, block.InstanceURL, block.Space, block.APIKey),
-			)
-		}
+	if len(unknown.findings) > 0 {
+		// the report is incomplete, so "none" above doesn't mean healthy
+		cancel()
+		os.Exit(1)
+	}
+}
+
+// newService builds a cdc.Service and the Octopus instances to run it
+// against, both making their API requests through httpClient.
+func newService(config mainConfig, httpClient httpDoer) (*cdc.Service, []cdc.Instance) {
+	service := &cdc.Service{
+		Metricly: metricly.New(config.Metricly.newClient(httpClient)),
+		Config:   config.CDC.toCDCConfig(),
+	}
+
+	return service, config.Octopus.instances(httpClient)
+}
 
-		if block.Label == "AOS" {
-			aosOcto = octopus.New(
-				octopus_http.New(httpClient, block.InstanceURL, block.Space, block.APIKey),
-			)
+func sendNotifications(ctx context.Context, notifiers []*notify.Notifier, report cdc.Report, changes []state.Change) {
+	for _, n := range notifiers {
+		if _, err := n.Notify(ctx, report, changes); err != nil {
+			log.Printf("Failed to send notification: %s", err)
 		}
 	}
+}
 
-	fmt.Println("Current CDC Install/Replication status:")
+// newRunContext returns a context that is cancelled by Ctrl-C, or once the
+// timeout elapses if it is non-zero.
+func newRunContext(timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
 
-	offline := make(chan unhealthyResult)
+	if timeout > 0 {
+		cancel()
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	}
 
-	go func() {
-		defer close(offline)
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
 
-		results, _ := service.CheckOfflineNUCs(asiOcto, config.Octopus.CDCProjects...)
-		for name, duration := range results {
-			offline <- unhealthyResult{name, duration}
+	go func() {
+		select {
+		case <-interrupt:
+			cancel()
+		case <-ctx.Done():
 		}
+
+		signal.Stop(interrupt)
 	}()
 
-	idle := make(chan unhealthyResult)
+	return ctx, cancel
+}
 
-	go func() {
-		defer close(idle)
+// newHTTPClient returns an HTTP client that retries transient failures,
+// logging each retry so that flaky APIs can be diagnosed.
+func newHTTPClient(cfg *retryConfig) *retry.Doer {
+	doer := retry.New(&http.Client{
+		Timeout: 10 * time.Second,
+	})
 
-		machines, _ := service.CheckIdleMachines(asiOcto, config.Octopus.CDCProjects...)
-		for name, latency := range machines {
-			idle <- unhealthyResult{name, latency}
+	doer.OnRetry = func(req *http.Request, attempt int, resp *http.Response, err error, delay time.Duration) {
+		reason := fmt.Sprintf("%v", err)
+		if resp != nil {
+			reason = resp.Status
 		}
-	}()
 
-	aosIdle := make(chan unhealthyResult)
+		log.Printf("%s %s attempt %d failed (%s); retrying in %s", req.Method, req.URL.Path, attempt, reason, delay)
+	}
 
-	go func() {
-		defer close(aosIdle)
+	if cfg == nil {
+		return doer
+	}
 
-		machines, _ := service.CheckIdleMachines(aosOcto, config.Octopus.CDCProjects...)
-		for name, latency := range machines {
-			aosIdle <- unhealthyResult{name, latency}
-		}
-	}()
+	doer.MaxAttempts = cfg.MaxAttempts
+	doer.BaseDelay = parseDuration("Retry.baseDelay", cfg.BaseDelay)
+	doer.MaxDelay = parseDuration("Retry.maxDelay", cfg.MaxDelay)
+
+	for _, method := range cfg.Methods {
+		doer.Methods = append(doer.Methods, strings.ToUpper(method))
+	}
+
+	return doer
+}
 
-	printOfflineNUCs(offline)
-	printIdleASIMachines(idle)
-	printIdleAOSMachines(aosIdle)
+// logHTTPStats logs how many API requests needed retrying or failed, if any
+// did, to help diagnose an incomplete report.
+func logHTTPStats(stats retry.Stats) {
+	if stats.Attempts == stats.Requests && stats.Failures == 0 {
+		return
+	}
+
+	log.Printf("Made %d API request(s) in %d attempt(s); %d failed after retrying", stats.Requests, stats.Attempts, stats.Failures)
 }
 
-func readConfigFile(cfg *mainConfig) {
-	usr, _ := user.Current()
-	configFile := fmt.Sprintf("%s/.monitoring/cdc_status.hcl", usr.HomeDir)
+// trackState records report's findings in the configured state file, and
+// returns how they changed since the last run. It returns nil if there is no
+// state file, or it can't be read.
+func trackState(cfg *stateConfig, report cdc.Report) []state.Change {
+	if cfg == nil {
+		return nil
+	}
 
-	err := hclsimple.DecodeFile(configFile, nil, cfg)
+	store := state.File{Path: cfg.File}
+
+	previous, err := store.Load()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %s", err)
+		log.Printf("Failed to load state: %s", err)
+		return nil
 	}
-}
 
-func printOfflineNUCs(nucsChan <-chan unhealthyResult) {
-	offlineStringChan := make(chan string)
+	next, changes := state.Update(previous, report)
 
-	go func() {
-		defer close(offlineStringChan)
-		for ir := range nucsChan {
-			offlineStringChan <- fmt.Sprintf("%s (offline for %.1f hours)", ir.name, ir.duration)
-		}
-	}()
+	if err := store.Save(next); err != nil {
+		log.Printf("Failed to save state: %s", err)
+	}
 
-	printFromChannel("NUCs offline this morning", offlineStringChan)
+	if changes == nil {
+		// distinguish "nothing changed" from "not tracking state"
+		changes = []state.Change{}
+	}
+
+	return changes
 }
 
-func printIdleASIMachines(idleChan <-chan unhealthyResult) {
-	idleStringChan := make(chan string)
+// newSections converts each of a cdc report's results into a report section.
+// changes may be nil if state isn't being tracked.
+func newSections(report cdc.Report, changes []state.Change) []section {
+	statuses := make(map[string]state.Change, len(changes))
 
-	go func() {
-		defer close(idleStringChan)
-		for ir := range idleChan {
-			idleStringChan <- fmt.Sprintf("%s (idle for %.1f hours)", ir.name, ir.duration/3600)
-		}
-	}()
+	for _, c := range changes {
+		statuses[findingKey(c.Instance, c.Check, c.Tenant)] = c
+	}
+
+	sections := make([]section, 0, len(report.Results))
+
+	for _, cr := range report.Results {
+		sections = append(sections, newSection(cr, statuses))
+	}
+
+	return sections
+}
 
-	printFromChannel("NUCs or VMs Online but not replicating", idleStringChan)
+func findingKey(instance string, check cdc.Check, tenant string) string {
+	return fmt.Sprintf("%s\x00%s\x00%s", instance, check, tenant)
 }
 
-func printIdleAOSMachines(idleChan <-chan unhealthyResult) {
-	idleStringChan := make(chan string)
+func newSection(cr cdc.CheckResult, statuses map[string]state.Change) section {
+	s := section{summary: fmt.Sprintf("%s %s", cr.DisplayName, cr.Check.Description())}
+
+	for _, f := range cr.Findings {
+		row := finding{
+			Tenant:   f.Tenant,
+			Category: string(cr.Check),
+			Hours:    f.Duration.Hours(),
+			Severity: string(f.Severity),
+			Instance: cr.Label,
+			Reason:   f.Reason,
+		}
 
-	go func() {
-		defer close(idleStringChan)
-		for ir := range idleChan {
-			idleStringChan <- fmt.Sprintf("%s (idle for %.1f hours)", ir.name, ir.duration/3600)
+		if c, ok := statuses[findingKey(cr.Label, cr.Check, f.Tenant)]; ok {
+			row.Status = string(c.Status)
+			row.FirstSeen = &c.FirstSeen
 		}
-	}()
 
-	printFromChannel("AOS Systems not replicating", idleStringChan)
+		s.findings = append(s.findings, row)
+	}
+
+	for _, e := range cr.Errors {
+		s.errors = append(s.errors, finding{
+			Tenant:   e.Tenant,
+			Category: categoryUnknown,
+			Instance: cr.Label,
+			Error:    fmt.Sprintf("%s: %s", s.summary, e),
+		})
+	}
+
+	return s
 }
 
-func printFromChannel(summary string, channel <-chan string) {
-	first := <-channel
+// recoveredSection lists the findings from the last run that are now healthy.
+func recoveredSection(changes []state.Change) section {
+	recovered := section{summary: "Recovered since the last run"}
+
+	for _, c := range changes {
+		if c.Status != state.StatusRecovered {
+			continue
+		}
 
-	fmt.Printf("  - %s:", summary)
+		firstSeen := c.FirstSeen
 
-	if first == "" {
-		// unset value means the channel closed without sending data
-		fmt.Println(" none")
-	} else {
-		fmt.Printf("\n    - %s\n", first)
+		recovered.findings = append(recovered.findings, finding{
+			Tenant:    c.Tenant,
+			Category:  string(c.Check),
+			Severity:  string(c.Severity),
+			Instance:  c.Instance,
+			Status:    string(c.Status),
+			FirstSeen: &firstSeen,
+		})
 	}
 
-	for n := range channel {
-		fmt.Printf("    - %s\n", n)
+	return recovered
+}
+
+// unknownSection gathers every section's errors, so that missing data is
+// reported rather than looking like a clean bill of health.
+func unknownSection(sections []section) section {
+	unknown := section{summary: "UNKNOWN (data could not be retrieved)"}
+
+	for _, s := range sections {
+		unknown.findings = append(unknown.findings, s.errors...)
 	}
+
+	return unknown
 }
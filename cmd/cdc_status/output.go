@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+	"github.com/michaelmosher/monitoring/pkg/state"
+)
+
+const categoryUnknown = "unknown"
+
+// finding is a single row of the report. Hours is always a duration in hours,
+// regardless of the units used by the check that produced it. Rows in the
+// "unknown" category carry an Error instead, and may have no Tenant. Status
+// and FirstSeen are only set when state is tracked between runs. Reason is
+// Octopus's explanation of an unhealthy machine, if it has one.
+type finding struct {
+	Tenant    string     `json:"tenant"`
+	Category  string     `json:"category"`
+	Hours     float64    `json:"hours"`
+	Severity  string     `json:"severity,omitempty"`
+	Instance  string     `json:"instance"`
+	Status    string     `json:"status,omitempty"`
+	FirstSeen *time.Time `json:"firstSeen,omitempty"`
+	Reason    string     `json:"reason,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
+type section struct {
+	summary  string
+	findings []finding
+	errors   []finding
+}
+
+type writer func(io.Writer, []section) error
+
+var writers = map[string]writer{
+	"text":     writeText,
+	"json":     writeJSON,
+	"csv":      writeCSV,
+	"markdown": writeMarkdown,
+}
+
+func allFindings(sections []section) []finding {
+	all := []finding{}
+
+	for _, s := range sections {
+		all = append(all, s.findings...)
+	}
+
+	return all
+}
+
+func writeText(w io.Writer, sections []section) error {
+	fmt.Fprintln(w, "Current CDC Install/Replication status:")
+
+	for _, s := range sections {
+		fmt.Fprintf(w, "  - %s:", s.summary)
+
+		if len(s.findings) == 0 {
+			fmt.Fprintln(w, " none")
+			continue
+		}
+
+		fmt.Fprintln(w)
+
+		for _, f := range s.findings {
+			switch {
+			case f.Category == categoryUnknown:
+				fmt.Fprintf(w, "    - %s\n", f.Error)
+			case f.Status == string(state.StatusRecovered):
+				fmt.Fprintf(w, "    - %s (%s %s since %s)\n", f.Tenant, f.Instance, f.Category, f.FirstSeen.Format("2006-01-02 15:04"))
+			default:
+				fmt.Fprintf(w, "    - %s%s\n", f.Tenant, describeText(f))
+			}
+		}
+	}
+
+	return nil
+}
+
+func describeText(f finding) string {
+	details := f.Category
+
+	if f.Hours > 0 {
+		details += fmt.Sprintf(" for %.1f hours", f.Hours)
+	}
+
+	if f.Severity == string(cdc.SeverityWarning) {
+		details += ", " + f.Severity
+	}
+
+	switch state.Status(f.Status) {
+	case state.StatusNew, state.StatusEscalated, state.StatusDeescalated:
+		details += ", " + strings.ToUpper(f.Status)
+	}
+
+	if f.Reason != "" {
+		return fmt.Sprintf(" (%s): %s", details, f.Reason)
+	}
+
+	return fmt.Sprintf(" (%s)", details)
+}
+
+func writeJSON(w io.Writer, sections []section) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(allFindings(sections))
+}
+
+func writeCSV(w io.Writer, sections []section) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"tenant", "category", "hours", "severity", "instance", "status", "first_seen", "reason", "error"})
+
+	for _, f := range allFindings(sections) {
+		cw.Write([]string{
+			f.Tenant, f.Category, fmt.Sprintf("%.2f", f.Hours), f.Severity, f.Instance, f.Status, formatTime(f.FirstSeen), f.Reason, f.Error,
+		})
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeMarkdown(w io.Writer, sections []section) error {
+	fmt.Fprintln(w, "| Tenant | Category | Hours | Severity | Instance | Status | Reason | Error |")
+	fmt.Fprintln(w, "| ------ | -------- | ----- | -------- | -------- | ------ | ------ | ----- |")
+
+	for _, f := range allFindings(sections) {
+		fmt.Fprintf(w, "| %s | %s | %.1f | %s | %s | %s | %s | %s |\n",
+			escapeMarkdown(f.Tenant), f.Category, f.Hours, f.Severity, f.Instance, f.Status, escapeMarkdown(f.Reason), escapeMarkdown(f.Error))
+	}
+
+	return nil
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+// markdownCell escapes the characters that would break a table row: a pipe
+// would start a new cell, and a line break would end the row.
+var markdownCell = strings.NewReplacer("|", `\|`, "\r\n", "<br>", "\n", "<br>", "\r", "<br>")
+
+func escapeMarkdown(s string) string {
+	return markdownCell.Replace(s)
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEscapeMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{"plain", "Machine is offline", "Machine is offline"},
+		{"pipe", "Healthy | Unavailable", `Healthy \| Unavailable`},
+		{"newline", "Disk full\nTentacle stopped", "Disk full<br>Tentacle stopped"},
+		{"CRLF", "Disk full\r\nTentacle stopped", "Disk full<br>Tentacle stopped"},
+		{"carriage return", "Disk full\rTentacle stopped", "Disk full<br>Tentacle stopped"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := escapeMarkdown(tt.s); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteMarkdownKeepsOneRowPerFinding(t *testing.T) {
+	sections := []section{{
+		summary: "ASI NUCs or VMs with health check warnings or errors",
+		findings: []finding{{
+			Tenant:   "Bayside Clinic",
+			Category: "degraded",
+			Severity: "warning",
+			Instance: "ASI",
+			Reason:   "Disk full\nTentacle | stopped",
+		}},
+	}}
+
+	var buf bytes.Buffer
+
+	if err := writeMarkdown(&buf, sections); err != nil {
+		t.Fatalf("writeMarkdown returned error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines, want a header, a separator and 1 row:\n%s", len(lines), buf.String())
+	}
+
+	want := `| Bayside Clinic | degraded | 0.0 | warning | ASI |  | Disk full<br>Tentacle \| stopped |  |`
+	if lines[2] != want {
+		t.Errorf("got row %q, want %q", lines[2], want)
+	}
+}
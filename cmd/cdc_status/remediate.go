@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+)
+
+// remediate re-runs an Octopus health check of every offline NUC before
+// printing the usual report, so that NUCs that have come back since their
+// last scheduled health check aren't escalated. Without -apply it only logs
+// which NUCs it would check.
+func remediate(args []string) {
+	flags := flag.NewFlagSet("cdc_status remediate", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile(), "path to the HCL configuration file")
+	format := flags.String("format", "text", "output format: text, json, csv or markdown")
+	timeout := flags.Duration("timeout", 0, "give up on the whole run after this long (0 means no limit)")
+	apply := flags.Bool("apply", false, "run the health checks, rather than only listing the NUCs that would be checked")
+	wait := flags.Duration("wait", 5*time.Minute, "how long to wait for each instance's health check to finish")
+	flags.Parse(args)
+
+	write, ok := writers[*format]
+	if !ok {
+		log.Fatalf("Unknown output format %q", *format)
+	}
+
+	var config mainConfig
+	readConfigFile(*configFile, &config)
+
+	ctx, cancel := newRunContext(*timeout)
+	defer cancel()
+
+	httpClient := newHTTPClient(config.Retry)
+	service, instances := newService(config, httpClient)
+
+	for _, instance := range instances {
+		if !runsCheck(instance, cdc.CheckOfflineNUCs) {
+			continue
+		}
+
+		rechecks, err := service.RecheckOfflineNUCs(ctx, instance, *wait, !*apply)
+		if err != nil {
+			log.Printf("Failed to re-check %s NUCs: %s", instance.Label, err)
+			continue
+		}
+
+		for _, r := range rechecks {
+			logRecheck(instance, r, *apply)
+		}
+	}
+
+	printReport(ctx, cancel, config, httpClient, service, instances, write)
+}
+
+func runsCheck(instance cdc.Instance, check cdc.Check) bool {
+	if len(instance.Checks) == 0 {
+		return true
+	}
+
+	for _, c := range instance.Checks {
+		if c == check {
+			return true
+		}
+	}
+
+	return false
+}
+
+func logRecheck(instance cdc.Instance, r cdc.Recheck, applied bool) {
+	tenants := strings.Join(r.Tenants, ", ")
+
+	switch {
+	case !applied:
+		log.Printf("Would re-check %s NUC %s (%s); use -apply to run the health check", instance.Label, r.Machine.Name, tenants)
+	case r.Err != nil:
+		log.Printf("Re-check of %s NUC %s (%s) failed: %s", instance.Label, r.Machine.Name, tenants, r.Err)
+	case r.Recovered():
+		log.Printf("%s NUC %s (%s) is back: %s", instance.Label, r.Machine.Name, tenants, r.Status)
+	default:
+		log.Printf("%s NUC %s (%s) is still %s after health check %s", instance.Label, r.Machine.Name, tenants, r.Status, r.Task.ID)
+	}
+}
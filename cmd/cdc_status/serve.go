@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+	"github.com/michaelmosher/monitoring/pkg/retry"
+)
+
+// serve runs the checks on an interval, and serves the results over HTTP in
+// the Prometheus exposition format.
+func serve(args []string) {
+	flags := flag.NewFlagSet("cdc_status serve", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile(), "path to the HCL configuration file")
+	listen := flags.String("listen", ":9273", "address to serve /metrics on")
+	interval := flags.Duration("interval", 5*time.Minute, "how often to run the checks")
+	flags.Parse(args)
+
+	var config mainConfig
+	readConfigFile(*configFile, &config)
+
+	ctx, cancel := newRunContext(0)
+	defer cancel()
+
+	exp := newExporter()
+	httpClient := newHTTPClient(config.Retry)
+	notifiers := newNotifiers(httpClient, config.Notify)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", exp)
+
+	server := &http.Server{Addr: *listen, Handler: mux}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	go func() {
+		ticker := time.NewTicker(*interval)
+		defer ticker.Stop()
+
+		// the service and instances, and so their caches, outlive each run
+		service, instances := newService(config, httpClient)
+
+		for {
+			runCtx, runCancel := context.WithTimeout(ctx, *interval)
+			report := service.Run(runCtx, instances...)
+			exp.update(report, httpClient.Stats())
+			recordHistory(config.History, report)
+			sendNotifications(runCtx, notifiers, report, trackState(config.State, report))
+			runCancel()
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	log.Printf("Serving metrics on %s/metrics, checking every %s", *listen, *interval)
+
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Failed to serve metrics: %s", err)
+	}
+}
+
+type checkKey struct {
+	label string
+	check cdc.Check
+}
+
+// checkState is the last successful result of one check, plus counters that
+// accumulate across every run.
+type checkState struct {
+	last        cdc.CheckResult
+	lastSuccess time.Time
+	runs        int
+	errors      map[string]int
+}
+
+// exporter keeps the latest snapshot of each check. A check that fails
+// outright (e.g. Octopus is unreachable) keeps serving its previous result,
+// whose age shows up in cdc_check_last_success_timestamp_seconds.
+type exporter struct {
+	mu        sync.RWMutex
+	checks    map[checkKey]*checkState
+	order     []checkKey
+	latencies map[string]float64
+	latencyAt time.Time
+	http      retry.Stats
+}
+
+func newExporter() *exporter {
+	return &exporter{
+		checks:    make(map[checkKey]*checkState),
+		latencies: make(map[string]float64),
+	}
+}
+
+func (e *exporter) update(report cdc.Report, http retry.Stats) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.http = http
+
+	for _, cr := range report.Results {
+		key := checkKey{cr.Label, cr.Check}
+		state, ok := e.checks[key]
+
+		if !ok {
+			state = &checkState{errors: make(map[string]int)}
+			e.checks[key] = state
+			e.order = append(e.order, key)
+		}
+
+		state.runs++
+
+		for _, err := range cr.Errors {
+			state.errors[err.Source]++
+		}
+
+		if !failedOutright(cr.Result) {
+			state.last = cr
+			state.lastSuccess = report.GeneratedAt
+		}
+	}
+
+	if len(report.Latencies) > 0 {
+		e.latencies = report.Latencies
+		e.latencyAt = report.GeneratedAt
+	}
+}
+
+// failedOutright reports whether a result has a source-level error, meaning
+// its findings say nothing about the tenants.
+func failedOutright(result cdc.Result) bool {
+	for _, err := range result.Errors {
+		if err.Tenant == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (e *exporter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	var offline, latency, degraded, deployments, unmapped, findings, runs, errors, lastSuccess []sample
+
+	for _, key := range e.order {
+		state := e.checks[key]
+		instance := label{"instance", key.label}
+		check := label{"check", string(key.check)}
+
+		runs = append(runs, sample{[]label{instance, check}, float64(state.runs)})
+
+		for source, n := range state.errors {
+			errors = append(errors, sample{[]label{instance, check, {"source", source}}, float64(n)})
+		}
+
+		if state.lastSuccess.IsZero() {
+			continue
+		}
+
+		lastSuccess = append(lastSuccess, sample{[]label{instance, check}, unixSeconds(state.lastSuccess)})
+		findings = append(findings, sample{[]label{instance, check}, float64(len(state.last.Findings))})
+
+		for _, f := range state.last.Findings {
+			labels := []label{instance, {"tenant", f.Tenant}, {"severity", string(f.Severity)}}
+
+			switch key.check {
+			case cdc.CheckOfflineNUCs:
+				offline = append(offline, sample{labels, f.Duration.Hours()})
+			case cdc.CheckIdleMachines:
+				latency = append(latency, sample{labels, f.Duration.Seconds()})
+			case cdc.CheckDegradedMachines:
+				degraded = append(degraded, sample{labels, 1})
+			case cdc.CheckDeployments:
+				deployments = append(deployments, sample{labels, f.Duration.Hours()})
+			case cdc.CheckUAIDMapping:
+				unmapped = append(unmapped, sample{labels, 1})
+			}
+		}
+	}
+
+	var hvr []sample
+
+	for uaid, seconds := range e.latencies {
+		hvr = append(hvr, sample{[]label{{"uaid", uaid}}, seconds})
+	}
+
+	writeMetric(w, "cdc_offline_hours", "How long an offline CDC tenant's NUC has been Unavailable.", "gauge", offline)
+	writeMetric(w, "cdc_idle_latency_seconds", "HVR latency of a CDC tenant that is online but not replicating.", "gauge", latency)
+	writeMetric(w, "cdc_degraded", "Set for a CDC tenant with a machine whose health check has warnings or errors.", "gauge", degraded)
+	writeMetric(w, "cdc_deployment_hours", "Age of a CDC tenant's failed or outdated latest deployment.", "gauge", deployments)
+	writeMetric(w, "cdc_uaid_unmapped", "Set for a CDC tenant or HVR metric (by FQN) that can't be mapped by UAID.", "gauge", unmapped)
+	writeMetric(w, "cdc_findings", "Number of unhealthy tenants found by a check.", "gauge", findings)
+	writeMetric(w, "cdc_hvr_latency_seconds", "Latest HVR latency sample per UAID.", "gauge", hvr)
+	writeMetric(w, "cdc_check_runs_total", "Number of times a check has run.", "counter", runs)
+	writeMetric(w, "cdc_check_errors_total", "Number of errors encountered by a check, by data source.", "counter", errors)
+	writeMetric(w, "cdc_http_requests_total", "Number of API requests made, however many attempts each took.", "counter",
+		[]sample{{nil, float64(e.http.Requests)}})
+	writeMetric(w, "cdc_http_attempts_total", "Number of API request attempts, including retries.", "counter",
+		[]sample{{nil, float64(e.http.Attempts)}})
+	writeMetric(w, "cdc_http_failures_total", "Number of API requests that failed after every attempt.", "counter",
+		[]sample{{nil, float64(e.http.Failures)}})
+	writeMetric(w, "cdc_check_last_success_timestamp_seconds", "When a check last produced a result.", "gauge", lastSuccess)
+
+	if !e.latencyAt.IsZero() {
+		writeMetric(w, "cdc_hvr_latency_timestamp_seconds", "When the HVR latency samples were fetched.", "gauge",
+			[]sample{{nil, unixSeconds(e.latencyAt)}})
+	}
+}
+
+type label struct {
+	name  string
+	value string
+}
+
+type sample struct {
+	labels []label
+	value  float64
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeMetric(w io.Writer, name string, help string, kind string, samples []sample) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+
+	lines := make([]string, 0, len(samples))
+
+	for _, s := range samples {
+		pairs := make([]string, 0, len(s.labels))
+
+		for _, l := range s.labels {
+			pairs = append(pairs, fmt.Sprintf(`%s="%s"`, l.name, labelEscaper.Replace(l.value)))
+		}
+
+		if len(pairs) == 0 {
+			lines = append(lines, fmt.Sprintf("%s %g", name, s.value))
+			continue
+		}
+
+		lines = append(lines, fmt.Sprintf("%s{%s} %g", name, strings.Join(pairs, ","), s.value))
+	}
+
+	sort.Strings(lines)
+
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
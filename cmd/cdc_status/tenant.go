@@ -0,0 +1,144 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+)
+
+// showTenant prints everything known about one tenant on each Octopus
+// instance: its projects, variables, machines, recent MachineCritical events
+// and current HVR latency.
+func showTenant(args []string) {
+	flags := flag.NewFlagSet("cdc_status tenant", flag.ExitOnError)
+	configFile := flags.String("config", defaultConfigFile(), "path to the HCL configuration file")
+	label := flags.String("instance", "", "only look on the Octopus instance with this label")
+	events := flags.Int("events", 5, "how many recent MachineCritical events to show per machine")
+	timeout := flags.Duration("timeout", 0, "give up after this long (0 means no limit)")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		log.Fatalf("Usage: cdc_status tenant [flags] <tenant name or ID>")
+	}
+
+	var config mainConfig
+	readConfigFile(*configFile, &config)
+
+	ctx, cancel := newRunContext(*timeout)
+	defer cancel()
+
+	service, instances := newService(config, newHTTPClient(config.Retry))
+	found := false
+
+	for _, instance := range instances {
+		if *label != "" && instance.Label != *label {
+			continue
+		}
+
+		profile, err := service.TenantProfile(ctx, instance, flags.Arg(0), *events)
+
+		if err == cdc.ErrTenantNotFound {
+			continue
+		}
+
+		if err != nil {
+			log.Printf("Failed to look up %s on %s: %s", flags.Arg(0), instance.Label, err)
+			continue
+		}
+
+		found = true
+		printProfile(profile)
+	}
+
+	if !found {
+		fmt.Printf("No tenant %q found\n", flags.Arg(0))
+		cancel()
+		os.Exit(1)
+	}
+}
+
+func printProfile(p cdc.Profile) {
+	fmt.Printf("%s (%s) on %s:\n", p.Tenant.Name, p.Tenant.ID, p.Instance)
+
+	projects := "none"
+	if len(p.CDCProjects) > 0 {
+		projects = strings.Join(p.CDCProjects, ", ")
+	}
+
+	fmt.Printf("  - CDC projects: %s\n", projects)
+
+	uaid := p.UAID
+	if uaid == "" {
+		uaid = "not set"
+	}
+
+	fmt.Printf("  - UAID: %s\n", uaid)
+
+	if p.LatencyErr != nil {
+		fmt.Printf("  - HVR latency: unknown (%s)\n", p.LatencyErr)
+	} else {
+		fmt.Printf("  - HVR latency: %s\n", p.Latency)
+	}
+
+	printVariables(p.Tenant.Variables)
+
+	if len(p.Machines) == 0 {
+		fmt.Println("  - Machines: none")
+		return
+	}
+
+	fmt.Println("  - Machines:")
+
+	for _, m := range p.Machines {
+		roles := make([]string, 0, len(m.Roles))
+		for role := range m.Roles {
+			roles = append(roles, role)
+		}
+
+		sort.Strings(roles)
+
+		status := string(m.Status)
+		if m.IsDisabled {
+			status += ", disabled"
+		}
+
+		fmt.Printf("    - %s (%s; %s; %s)\n", m.Name, status, strings.Join(roles, ", "), m.Endpoint.CommunicationStyle)
+
+		if m.StatusSummary != "" {
+			fmt.Printf("      %s\n", m.StatusSummary)
+		}
+
+		if m.EventsErr != nil {
+			fmt.Printf("      events unknown: %s\n", m.EventsErr)
+			continue
+		}
+
+		for _, e := range m.Events {
+			fmt.Printf("      %s %s\n", e.Occurred.Local().Format("2006-01-02 15:04"), e.Category)
+		}
+	}
+}
+
+func printVariables(variables map[string]string) {
+	if len(variables) == 0 {
+		return
+	}
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	fmt.Println("  - Variables:")
+
+	for _, name := range names {
+		fmt.Printf("    - %s = %s\n", name, variables[name])
+	}
+}
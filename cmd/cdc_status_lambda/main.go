@@ -3,18 +3,303 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-lambda-go/lambda"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+	"github.com/michaelmosher/monitoring/pkg/metricly"
+	metricly_http "github.com/michaelmosher/monitoring/pkg/metricly/http"
+	"github.com/michaelmosher/monitoring/pkg/octopus"
+	octopus_http "github.com/michaelmosher/monitoring/pkg/octopus/http"
+	"github.com/michaelmosher/monitoring/pkg/retry"
 )
 
-type MyEvent struct {
-	Name string `json:"name"`
+// octopusCacheTTL is how long an invocation's checks share Octopus data; a
+// checker is only used for one invocation.
+const octopusCacheTTL = time.Minute
+
+type octopusCredentials struct {
+	InstanceURL string `json:"instanceURL"`
+	APIKey      string `json:"apiKey"`
+	Space       string `json:"space"`
+	HubElement  string `json:"hubElement"`
+}
+
+// Config holds everything needed for a single invocation. It is read from the
+// Lambda environment, and any non-empty value in the invocation payload
+// overrides the environment.
+type Config struct {
+	MetriclyUsername string             `json:"metriclyUsername"`
+	MetriclyPassword string             `json:"metriclyPassword"`
+	MetriclyAPIKey   string             `json:"metriclyApiKey"`
+	MetriclyRegion   string             `json:"metriclyRegion"`
+	MetriclyURL      string             `json:"metriclyURL"`
+	ASI              octopusCredentials `json:"asi"`
+	AOS              octopusCredentials `json:"aos"`
+	CDCProjects      []string           `json:"cdcProjects"`
+}
+
+// Finding is a single unhealthy tenant. Durations are always reported in hours.
+// Instance is the label (ASI or AOS) of the Octopus instance it was found on.
+type Finding struct {
+	Tenant   string  `json:"tenant"`
+	Instance string  `json:"instance"`
+	Hours    float64 `json:"hours"`
+	Severity string  `json:"severity"`
+	Reason   string  `json:"reason,omitempty"`
+}
+
+// Report is the JSON document returned by the handler. A non-empty Errors
+// list means the findings are incomplete.
+type Report struct {
+	GeneratedAt     time.Time `json:"generatedAt"`
+	OfflineNUCs     []Finding `json:"offlineNUCs"`
+	IdleASIMachines []Finding `json:"idleASIMachines"`
+	IdleAOSMachines []Finding `json:"idleAOSMachines"`
+	Degraded        []Finding `json:"degradedMachines"`
+	UAIDMapping     []Finding `json:"uaidMapping"`
+	Errors          []string  `json:"errors,omitempty"`
 }
 
-func HandleRequest(ctx context.Context, name MyEvent) (string, error) {
-	return fmt.Sprintf("Hello %s!", name.Name), nil
+type checker struct {
+	service   *cdc.Service
+	instances []cdc.Instance
+}
+
+type handler struct {
+	env   Config
+	build func(Config) checker
 }
 
 func main() {
-	lambda.Start(HandleRequest)
+	h := handler{
+		env:   configFromEnv(),
+		build: newChecker,
+	}
+
+	lambda.Start(h.HandleRequest)
+}
+
+// HandleRequest runs the CDC checks and returns a structured report.
+func (h handler) HandleRequest(ctx context.Context, event Config) (Report, error) {
+	cfg := h.env.merge(event)
+
+	if err := cfg.validate(); err != nil {
+		return Report{}, err
+	}
+
+	return h.build(cfg).run(ctx), nil
+}
+
+func configFromEnv() Config {
+	var projects []string
+
+	for _, p := range strings.Split(os.Getenv("CDC_PROJECTS"), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			projects = append(projects, p)
+		}
+	}
+
+	return Config{
+		MetriclyUsername: os.Getenv("METRICLY_USERNAME"),
+		MetriclyPassword: os.Getenv("METRICLY_PASSWORD"),
+		MetriclyAPIKey:   os.Getenv("METRICLY_API_KEY"),
+		MetriclyRegion:   os.Getenv("METRICLY_REGION"),
+		MetriclyURL:      os.Getenv("METRICLY_URL"),
+		ASI: octopusCredentials{
+			InstanceURL: os.Getenv("ASI_OCTOPUS_URL"),
+			APIKey:      os.Getenv("ASI_OCTOPUS_API_KEY"),
+			Space:       os.Getenv("ASI_OCTOPUS_SPACE"),
+			HubElement:  os.Getenv("ASI_HUB_ELEMENT"),
+		},
+		AOS: octopusCredentials{
+			InstanceURL: os.Getenv("AOS_OCTOPUS_URL"),
+			APIKey:      os.Getenv("AOS_OCTOPUS_API_KEY"),
+			Space:       os.Getenv("AOS_OCTOPUS_SPACE"),
+			HubElement:  os.Getenv("AOS_HUB_ELEMENT"),
+		},
+		CDCProjects: projects,
+	}
+}
+
+func (c Config) merge(o Config) Config {
+	c.MetriclyUsername = override(c.MetriclyUsername, o.MetriclyUsername)
+	c.MetriclyPassword = override(c.MetriclyPassword, o.MetriclyPassword)
+	c.MetriclyAPIKey = override(c.MetriclyAPIKey, o.MetriclyAPIKey)
+	c.MetriclyRegion = override(c.MetriclyRegion, o.MetriclyRegion)
+	c.MetriclyURL = override(c.MetriclyURL, o.MetriclyURL)
+	c.ASI = c.ASI.merge(o.ASI)
+	c.AOS = c.AOS.merge(o.AOS)
+
+	if len(o.CDCProjects) > 0 {
+		c.CDCProjects = o.CDCProjects
+	}
+
+	return c
+}
+
+func (c octopusCredentials) merge(o octopusCredentials) octopusCredentials {
+	c.InstanceURL = override(c.InstanceURL, o.InstanceURL)
+	c.APIKey = override(c.APIKey, o.APIKey)
+	c.Space = override(c.Space, o.Space)
+	c.HubElement = override(c.HubElement, o.HubElement)
+
+	return c
+}
+
+func override(current string, value string) string {
+	if value != "" {
+		return value
+	}
+
+	return current
+}
+
+func (c Config) validate() error {
+	if c.MetriclyAPIKey == "" && (c.MetriclyUsername == "" || c.MetriclyPassword == "") {
+		return fmt.Errorf("missing Metricly credentials")
+	}
+
+	if c.MetriclyRegion != "" && c.MetriclyURL != "" {
+		return fmt.Errorf("set either a Metricly region or URL, not both")
+	}
+
+	if c.MetriclyRegion != "" {
+		if _, err := metricly_http.RegionURL(c.MetriclyRegion); err != nil {
+			return err
+		}
+	}
+
+	if c.ASI.InstanceURL == "" || c.ASI.APIKey == "" {
+		return fmt.Errorf("missing ASI Octopus credentials")
+	}
+
+	if c.AOS.InstanceURL == "" || c.AOS.APIKey == "" {
+		return fmt.Errorf("missing AOS Octopus credentials")
+	}
+
+	if len(c.CDCProjects) == 0 {
+		return fmt.Errorf("no CDC projects configured")
+	}
+
+	return nil
+}
+
+// metriclyURL returns the configured Metricly URL, which is empty for the
+// default region.
+func (c Config) metriclyURL() string {
+	if c.MetriclyRegion == "" {
+		return c.MetriclyURL
+	}
+
+	url, _ := metricly_http.RegionURL(c.MetriclyRegion)
+	return url
+}
+
+func newChecker(cfg Config) checker {
+	httpClient := retry.New(&http.Client{
+		Timeout: 10 * time.Second,
+	})
+
+	return checker{
+		service: &cdc.Service{
+			Metricly: metricly.New(
+				metricly_http.New(httpClient, cfg.metriclyURL(), metricly_http.Credentials{
+					Username: cfg.MetriclyUsername,
+					Password: cfg.MetriclyPassword,
+					APIKey:   cfg.MetriclyAPIKey,
+				}),
+			),
+		},
+		instances: []cdc.Instance{
+			{
+				Label: "ASI",
+				Octopus: octopus.New(octopus.NewCache(
+					octopus_http.New(httpClient, cfg.ASI.InstanceURL, cfg.ASI.Space, cfg.ASI.APIKey),
+					octopusCacheTTL,
+				)),
+				Projects:   cfg.CDCProjects,
+				HubElement: cfg.ASI.HubElement,
+				Checks:     []cdc.Check{cdc.CheckOfflineNUCs, cdc.CheckIdleMachines, cdc.CheckDegradedMachines, cdc.CheckUAIDMapping},
+			},
+			{
+				Label: "AOS",
+				Octopus: octopus.New(octopus.NewCache(
+					octopus_http.New(httpClient, cfg.AOS.InstanceURL, cfg.AOS.Space, cfg.AOS.APIKey),
+					octopusCacheTTL,
+				)),
+				Projects:   cfg.CDCProjects,
+				HubElement: cfg.AOS.HubElement,
+				Checks:     aosChecks(cfg.AOS.HubElement),
+			},
+		},
+	}
+}
+
+// aosChecks are the checks run against AOS. Its tenants are only matched to
+// latency metrics if it has its own hub element, since they aren't replicated
+// by the default (ASI) one.
+func aosChecks(hubElement string) []cdc.Check {
+	checks := []cdc.Check{cdc.CheckIdleMachines, cdc.CheckDegradedMachines}
+
+	if hubElement != "" {
+		checks = append(checks, cdc.CheckUAIDMapping)
+	}
+
+	return checks
+}
+
+func (c checker) run(ctx context.Context) Report {
+	results := c.service.Run(ctx, c.instances...)
+	report := Report{
+		GeneratedAt:     results.GeneratedAt,
+		OfflineNUCs:     []Finding{},
+		IdleASIMachines: []Finding{},
+		IdleAOSMachines: []Finding{},
+		Degraded:        []Finding{},
+		UAIDMapping:     []Finding{},
+	}
+
+	for _, cr := range results.Results {
+		section := fmt.Sprintf("%s %s", cr.Check, cr.Label)
+
+		for _, err := range cr.Errors {
+			report.Errors = append(report.Errors, fmt.Sprintf("%s: %s", section, err))
+		}
+
+		switch {
+		case cr.Check == cdc.CheckOfflineNUCs:
+			report.OfflineNUCs = append(report.OfflineNUCs, toFindings(cr)...)
+		case cr.Check == cdc.CheckDegradedMachines:
+			report.Degraded = append(report.Degraded, toFindings(cr)...)
+		case cr.Check == cdc.CheckUAIDMapping:
+			report.UAIDMapping = append(report.UAIDMapping, toFindings(cr)...)
+		case cr.Label == "ASI":
+			report.IdleASIMachines = toFindings(cr)
+		default:
+			report.IdleAOSMachines = toFindings(cr)
+		}
+	}
+
+	return report
+}
+
+func toFindings(cr cdc.CheckResult) []Finding {
+	findings := make([]Finding, 0, len(cr.Findings))
+
+	for _, f := range cr.Findings {
+		findings = append(findings, Finding{
+			Tenant:   f.Tenant,
+			Instance: cr.Label,
+			Hours:    f.Duration.Hours(),
+			Severity: string(f.Severity),
+			Reason:   f.Reason,
+		})
+	}
+
+	return findings
 }
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/michaelmosher/monitoring/pkg/fake"
+)
+
+func TestHandleRequest(t *testing.T) {
+	asi := fake.NewOctopus(fake.Fixtures())
+	defer asi.Close()
+
+	aos := fake.NewOctopus(fake.Fixtures())
+	defer aos.Close()
+
+	m := fake.NewMetricly(fake.Fixtures())
+	defer m.Close()
+
+	var built []Config
+
+	h := handler{
+		env: Config{
+			MetriclyAPIKey: fake.MetriclyAPIKey,
+			MetriclyURL:    m.URL,
+			ASI:            octopusCredentials{InstanceURL: asi.URL, APIKey: fake.OctopusAPIKey, Space: fake.OctopusSpace},
+			AOS:            octopusCredentials{InstanceURL: aos.URL, APIKey: "API-STALE", Space: fake.OctopusSpace},
+		},
+		build: func(cfg Config) checker {
+			built = append(built, cfg)
+			return newChecker(cfg)
+		},
+	}
+
+	report, err := h.HandleRequest(context.Background(), Config{
+		AOS:         octopusCredentials{APIKey: fake.OctopusAPIKey},
+		CDCProjects: []string{"CDC Replication"},
+	})
+
+	if err != nil {
+		t.Fatalf("HandleRequest returned error: %v", err)
+	}
+
+	if len(built) != 1 || built[0].AOS.APIKey != fake.OctopusAPIKey || built[0].AOS.InstanceURL != aos.URL {
+		t.Errorf("built %+v, want the environment with the payload's AOS API key", built)
+	}
+
+	if len(report.Errors) != 0 {
+		t.Errorf("got errors %v, want none", report.Errors)
+	}
+
+	if report.GeneratedAt.IsZero() {
+		t.Errorf("GeneratedAt is not set")
+	}
+
+	sections := []struct {
+		name     string
+		findings []Finding
+		want     []string
+	}{
+		{"offlineNUCs", report.OfflineNUCs, []string{"ASI Acme Health (critical)"}},
+		{"idleASIMachines", report.IdleASIMachines, []string{"ASI Bayside Clinic (critical)", "ASI Cedar Hospital (critical)"}},
+		{"idleAOSMachines", report.IdleAOSMachines, []string{"AOS Bayside Clinic (critical)", "AOS Cedar Hospital (critical)"}},
+		{"degradedMachines", report.Degraded, []string{"ASI Bayside Clinic (warning)", "AOS Bayside Clinic (warning)"}},
+		// AOS has no hub element of its own, so its UAIDs aren't checked
+		{"uaidMapping", report.UAIDMapping, []string{"ASI hvr_latency_ua0005 (warning)"}},
+	}
+
+	for _, s := range sections {
+		got := make([]string, 0, len(s.findings))
+		for _, f := range s.findings {
+			got = append(got, fmt.Sprintf("%s %s (%s)", f.Instance, f.Tenant, f.Severity))
+		}
+
+		if !reflect.DeepEqual(got, s.want) {
+			t.Errorf("%s: got %v, want %v", s.name, got, s.want)
+		}
+	}
+
+	if hours := report.OfflineNUCs[0].Hours; hours <= 0 {
+		t.Errorf("got an offline NUC for %v hours, want a positive duration", hours)
+	}
+}
+
+func TestHandleRequestValidation(t *testing.T) {
+	valid := Config{
+		MetriclyUsername: "user",
+		MetriclyPassword: "password",
+		ASI:              octopusCredentials{InstanceURL: "https://asi.octopus.app", APIKey: "API-ASI"},
+		AOS:              octopusCredentials{InstanceURL: "https://aos.octopus.app", APIKey: "API-AOS"},
+		CDCProjects:      []string{"CDC Replication"},
+	}
+
+	tests := []struct {
+		name    string
+		edit    func(*Config)
+		wantErr string
+	}{
+		{"no Metricly credentials", func(c *Config) { c.MetriclyUsername, c.MetriclyPassword = "", "" }, "missing Metricly credentials"},
+		{"no Metricly password", func(c *Config) { c.MetriclyPassword = "" }, "missing Metricly credentials"},
+		{"Metricly region and URL", func(c *Config) { c.MetriclyRegion, c.MetriclyURL = "eu", "https://metricly.example.com" }, "set either a Metricly region or URL, not both"},
+		{"unknown Metricly region", func(c *Config) { c.MetriclyRegion = "mars" }, "mars"},
+		{"no ASI URL", func(c *Config) { c.ASI.InstanceURL = "" }, "missing ASI Octopus credentials"},
+		{"no AOS API key", func(c *Config) { c.AOS.APIKey = "" }, "missing AOS Octopus credentials"},
+		{"no CDC projects", func(c *Config) { c.CDCProjects = nil }, "no CDC projects configured"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := valid
+			tt.edit(&env)
+
+			h := handler{
+				env: env,
+				build: func(Config) checker {
+					t.Fatalf("build called for an invalid config")
+					return checker{}
+				},
+			}
+
+			report, err := h.HandleRequest(context.Background(), Config{})
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(report, Report{}) {
+				t.Errorf("got report %+v, want an empty one", report)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package cdc
+
+import (
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/metricly"
+)
+
+const (
+	nucOctopusRole = "side-server-appliances"
+	vmOctopusRole  = "linux-server"
+	dbOctopusRole  = "sql-server"
+)
+
+// Severity ranks how unhealthy a Finding is.
+type Severity string
+
+const (
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Thresholds are the replication latencies above which a tenant is reported
+// as a warning or as critical. A zero Warning means "same as Critical".
+type Thresholds struct {
+	Warning  time.Duration
+	Critical time.Duration
+}
+
+// severity returns the severity of latency, and false if it is healthy.
+func (t Thresholds) severity(latency time.Duration) (Severity, bool) {
+	warning := t.Warning
+	if warning <= 0 || warning > t.Critical {
+		warning = t.Critical
+	}
+
+	switch {
+	case latency > t.Critical:
+		return SeverityCritical, true
+	case latency > warning:
+		return SeverityWarning, true
+	default:
+		return "", false
+	}
+}
+
+// sustainedSeverity is like severity, but a tenant is only unhealthy if its
+// samples have been above a threshold for at least sustained.For in total.
+// Each sample counts for sustained.Resolution.
+func (t Thresholds) sustainedSeverity(samples []metricly.Sample, sustained Sustained) (Severity, bool) {
+	var critical, warning time.Duration
+
+	for _, sample := range samples {
+		latency := time.Duration(sample.Value * float64(time.Second))
+
+		switch severity, _ := t.severity(latency); severity {
+		case SeverityCritical:
+			critical += sustained.Resolution
+			warning += sustained.Resolution
+		case SeverityWarning:
+			warning += sustained.Resolution
+		}
+	}
+
+	switch {
+	case critical >= sustained.For:
+		return SeverityCritical, true
+	case warning >= sustained.For:
+		return SeverityWarning, true
+	default:
+		return "", false
+	}
+}
+
+// stricter returns whichever of t and o reports problems sooner.
+func (t Thresholds) stricter(o Thresholds) Thresholds {
+	if o.Critical < t.Critical {
+		return o
+	}
+
+	return t
+}
+
+// Sustained makes CheckIdleMachines look at a series of latency samples,
+// rather than just the latest one, so that a single spike isn't reported.
+// For example, Window 20m, For 15m and Resolution 1m reports a tenant whose
+// latency was above a threshold for 15 of the last 20 minutes.
+type Sustained struct {
+	// Window is how much latency history to fetch.
+	Window time.Duration
+	// For is how long, in total, latency must be above a threshold.
+	For time.Duration
+	// Resolution is the time covered by each sample.
+	Resolution time.Duration
+	// Rollup is how Metricly combines data points within each sample.
+	Rollup metricly.Rollup
+}
+
+func (s Sustained) enabled() bool {
+	return s.For > 0
+}
+
+func (s Sustained) sampleQuery() metricly.SampleQuery {
+	return metricly.SampleQuery{
+		Duration:   s.Window,
+		Rollup:     s.Rollup,
+		Resolution: s.Resolution,
+	}
+}
+
+// Config controls which Octopus machines and Metricly metrics the checks look
+// at, and what they consider unhealthy. Any zero-valued field falls back to
+// the corresponding DefaultConfig value.
+type Config struct {
+	// HubElement is the Metricly element of the HVR hub reporting latency.
+	HubElement string
+	// LatencyMetric is the Metricly metric holding per-UAID HVR latency.
+	LatencyMetric string
+	// SampleWindow is how far back to look for latency metrics.
+	SampleWindow time.Duration
+	// SampleTTL is how long latency samples are reused, by checks in the same
+	// run or by later runs of the same Service.
+	SampleTTL time.Duration
+	// SampleBatchSize is how many metrics' samples are fetched per request.
+	// If Metricly can't fetch them in batches, SampleWorkers metrics are
+	// fetched concurrently instead, one per request.
+	SampleBatchSize int
+	SampleWorkers   int
+
+	// OfflineRoles are the Octopus roles checked by CheckOfflineNUCs.
+	OfflineRoles []string
+	// IdleRoles are the Octopus roles checked by CheckIdleMachines.
+	IdleRoles []string
+	// DegradedRoles are the Octopus roles checked by CheckDegradedMachines.
+	DegradedRoles []string
+
+	// MaxReleasesBehind is how many releases of a CDC project a tenant may
+	// lag behind before CheckDeployments reports it.
+	MaxReleasesBehind int
+
+	// Latency is the default idle threshold. RoleLatency (keyed by Octopus
+	// role) and ProjectLatency (keyed by Octopus project name) override it;
+	// when several apply to a tenant, the strictest wins.
+	Latency        Thresholds
+	RoleLatency    map[string]Thresholds
+	ProjectLatency map[string]Thresholds
+
+	// Sustained is disabled unless For is set; when disabled, only the latest
+	// latency sample is considered.
+	Sustained Sustained
+}
+
+// DefaultConfig returns the settings for the production ASI HVR hub.
+func DefaultConfig() Config {
+	return Config{
+		HubElement:        "prod-hvr-hub-asi-001",
+		LatencyMetric:     "hvr_latency",
+		SampleWindow:      time.Hour,
+		SampleTTL:         time.Minute,
+		SampleBatchSize:   100,
+		SampleWorkers:     8,
+		OfflineRoles:      []string{nucOctopusRole},
+		IdleRoles:         []string{nucOctopusRole, vmOctopusRole, dbOctopusRole},
+		DegradedRoles:     []string{nucOctopusRole, vmOctopusRole, dbOctopusRole},
+		MaxReleasesBehind: 3,
+		Latency: Thresholds{
+			Warning:  600 * time.Second,
+			Critical: 600 * time.Second,
+		},
+	}
+}
+
+func (c Config) withDefaults() Config {
+	d := DefaultConfig()
+
+	if c.HubElement == "" {
+		c.HubElement = d.HubElement
+	}
+
+	if c.LatencyMetric == "" {
+		c.LatencyMetric = d.LatencyMetric
+	}
+
+	if c.SampleWindow <= 0 {
+		c.SampleWindow = d.SampleWindow
+	}
+
+	if c.SampleTTL <= 0 {
+		c.SampleTTL = d.SampleTTL
+	}
+
+	if c.SampleBatchSize <= 0 {
+		c.SampleBatchSize = d.SampleBatchSize
+	}
+
+	if c.SampleWorkers <= 0 {
+		c.SampleWorkers = d.SampleWorkers
+	}
+
+	if len(c.OfflineRoles) == 0 {
+		c.OfflineRoles = d.OfflineRoles
+	}
+
+	if len(c.IdleRoles) == 0 {
+		c.IdleRoles = d.IdleRoles
+	}
+
+	if len(c.DegradedRoles) == 0 {
+		c.DegradedRoles = d.DegradedRoles
+	}
+
+	if c.MaxReleasesBehind <= 0 {
+		c.MaxReleasesBehind = d.MaxReleasesBehind
+	}
+
+	if c.Latency.Critical <= 0 {
+		c.Latency = d.Latency
+	}
+
+	if c.Sustained.enabled() {
+		if c.Sustained.Window < c.Sustained.For {
+			c.Sustained.Window = c.Sustained.For
+		}
+
+		if c.Sustained.Resolution <= 0 {
+			c.Sustained.Resolution = time.Minute
+		}
+
+		if c.Sustained.Rollup == "" {
+			c.Sustained.Rollup = metricly.RollupAvg
+		}
+	}
+
+	return c
+}
+
+// latencyThresholds returns the thresholds for a tenant with the given
+// machine roles and (ID -> name) projects.
+func (c Config) latencyThresholds(roles map[string]struct{}, projects map[string]string) Thresholds {
+	var matched []Thresholds
+
+	for role := range roles {
+		if t, ok := c.RoleLatency[role]; ok {
+			matched = append(matched, t)
+		}
+	}
+
+	for _, name := range projects {
+		if t, ok := c.ProjectLatency[name]; ok {
+			matched = append(matched, t)
+		}
+	}
+
+	if len(matched) == 0 {
+		return c.Latency
+	}
+
+	strictest := matched[0]
+
+	for _, t := range matched[1:] {
+		strictest = strictest.stricter(t)
+	}
+
+	return strictest
+}
+
+func hasAnyRole(roles map[string]struct{}, wanted []string) bool {
+	for _, role := range wanted {
+		if _, ok := roles[role]; ok {
+			return true
+		}
+	}
+
+	return false
+}
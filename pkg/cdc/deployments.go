@@ -0,0 +1,138 @@
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/octopus"
+)
+
+// CheckDeployments reports CDC tenants whose latest deployment of a CDC
+// project failed (critical), or whose deployed release is more than
+// Config.MaxReleasesBehind releases older than the project's latest (a
+// warning), with how long ago that deployment was made.
+func (s *Service) CheckDeployments(ctx context.Context, octo octopusClient, projectNames ...string) Result {
+	var result Result
+	cfg := s.Config.withDefaults()
+
+	tenants, err := getOctopusTenants(ctx, octo)
+
+	if err != nil {
+		result.addError("octopus", "", err)
+		return result
+	}
+
+	projects, err := getOctopusProjectIDs(ctx, octo, projectNames...)
+
+	if err != nil {
+		result.addError("octopus", "", err)
+		return result
+	}
+
+	projectIDs := make([]string, 0, len(projects))
+	recent := make(map[string]map[string]struct{}, len(projects))
+
+	for id := range projects {
+		projectIDs = append(projectIDs, id)
+
+		releases, err := octo.FetchReleases(ctx, id, cfg.MaxReleasesBehind+1)
+
+		if err != nil {
+			result.addError("octopus", "", fmt.Errorf("octopus.FetchReleases(%s) error: %s", projects[id], err))
+			return result
+		}
+
+		recent[id] = make(map[string]struct{}, len(releases))
+
+		for _, release := range releases {
+			recent[id][release.ID] = struct{}{}
+		}
+	}
+
+	sort.Strings(projectIDs)
+
+	items, err := octo.FetchDashboard(ctx, projectIDs)
+
+	if err != nil {
+		result.addError("octopus", "", fmt.Errorf("octopus.FetchDashboard error: %s", err))
+		return result
+	}
+
+	for tenantID, latest := range latestDeployments(items) {
+		tenant := tenants[tenantID]
+
+		if len(tenantProjects(tenant, projects)) == 0 {
+			continue
+		}
+
+		var (
+			severity Severity
+			since    time.Duration
+			reasons  []string
+		)
+
+		for _, item := range latest {
+			project := projects[item.ProjectID]
+			_, isRecent := recent[item.ProjectID][item.ReleaseID]
+
+			switch {
+			case item.Failed():
+				severity = SeverityCritical
+				reason := fmt.Sprintf("%s %s deployment %s", project, item.ReleaseVersion, strings.ToLower(string(item.State)))
+
+				if item.ErrorMessage != "" {
+					reason += ": " + item.ErrorMessage
+				}
+
+				reasons = append(reasons, reason)
+			case item.IsCompleted && !isRecent:
+				if severity == "" {
+					severity = SeverityWarning
+				}
+
+				reasons = append(reasons, fmt.Sprintf("%s %s is more than %d releases behind", project, item.ReleaseVersion, cfg.MaxReleasesBehind))
+			default:
+				continue
+			}
+
+			if d := time.Since(item.Created); d > since {
+				since = d
+			}
+		}
+
+		if len(reasons) == 0 {
+			continue
+		}
+
+		sort.Strings(reasons)
+		result.addFinding(tenant.Name, since, severity, strings.Join(reasons, "; "))
+	}
+
+	result.sort()
+	return result
+}
+
+// latestDeployments returns each tenant's most recent dashboard item per
+// project, across environments.
+func latestDeployments(items []octopus.DashboardItem) map[string]map[string]octopus.DashboardItem {
+	latest := make(map[string]map[string]octopus.DashboardItem)
+
+	for _, item := range items {
+		if item.TenantID == "" {
+			continue
+		}
+
+		if _, ok := latest[item.TenantID]; !ok {
+			latest[item.TenantID] = make(map[string]octopus.DashboardItem)
+		}
+
+		if current, ok := latest[item.TenantID][item.ProjectID]; !ok || item.Created.After(current.Created) {
+			latest[item.TenantID][item.ProjectID] = item
+		}
+	}
+
+	return latest
+}
@@ -0,0 +1,194 @@
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Check names one of the CDC checks that can be run against an Octopus
+// instance.
+type Check string
+
+const (
+	CheckOfflineNUCs      Check = "offline"
+	CheckIdleMachines     Check = "idle"
+	CheckDegradedMachines Check = "degraded"
+	CheckDeployments      Check = "deployments"
+	CheckUAIDMapping      Check = "uaid"
+)
+
+// AllChecks lists every Check, in the order they are reported.
+var AllChecks = []Check{CheckOfflineNUCs, CheckIdleMachines, CheckDegradedMachines, CheckDeployments, CheckUAIDMapping}
+
+// DefaultChecks are the checks run against an Instance that doesn't list
+// any. CheckUAIDMapping is left out: it matches every instance against
+// Config.HubElement unless the instance sets its own, and would report all of
+// another hub's tenants as unmapped.
+var DefaultChecks = []Check{CheckOfflineNUCs, CheckIdleMachines, CheckDegradedMachines, CheckDeployments}
+
+// Description is a human-readable summary of what a Check reports.
+func (c Check) Description() string {
+	switch c {
+	case CheckOfflineNUCs:
+		return "NUCs offline"
+	case CheckIdleMachines:
+		return "NUCs or VMs online but not replicating"
+	case CheckDegradedMachines:
+		return "NUCs or VMs with health check warnings or errors"
+	case CheckDeployments:
+		return "tenants with a failed or outdated CDC deployment"
+	case CheckUAIDMapping:
+		return "tenants or HVR metrics with a missing, duplicate or malformed UAID"
+	default:
+		return string(c)
+	}
+}
+
+// ParseCheck converts a check name (e.g. from a config file) into a Check.
+func ParseCheck(name string) (Check, error) {
+	for _, c := range AllChecks {
+		if string(c) == name {
+			return c, nil
+		}
+	}
+
+	return "", fmt.Errorf("unknown check %q", name)
+}
+
+// Instance is an Octopus instance to monitor, and which checks apply to it.
+type Instance struct {
+	// Label identifies the instance, e.g. in machine-readable output.
+	Label string
+	// DisplayName is used in human-readable output; it defaults to Label.
+	DisplayName string
+	Octopus     octopusClient
+	Projects    []string
+	// HubElement is the Metricly element of the HVR hub replicating this
+	// instance's tenants; it defaults to Config.HubElement.
+	HubElement string
+	// Checks defaults to DefaultChecks.
+	Checks []Check
+}
+
+func (i Instance) name() string {
+	if i.DisplayName != "" {
+		return i.DisplayName
+	}
+
+	return i.Label
+}
+
+// config returns cfg with the instance's hub element, if it has one.
+func (i Instance) config(cfg Config) Config {
+	if i.HubElement != "" {
+		cfg.HubElement = i.HubElement
+	}
+
+	return cfg
+}
+
+func (i Instance) checks() []Check {
+	if len(i.Checks) == 0 {
+		return DefaultChecks
+	}
+
+	return i.Checks
+}
+
+// CheckResult is the Result of running one Check against one Instance.
+type CheckResult struct {
+	Label       string
+	DisplayName string
+	Check       Check
+	Result
+}
+
+// Report is the outcome of a Run. Latencies holds the HVR latency samples
+// (in seconds, keyed by UAID) that the idle checks were based on, from every
+// instance's hub element.
+type Report struct {
+	GeneratedAt time.Time
+	Results     []CheckResult
+	Latencies   map[string]float64
+}
+
+// Complete reports whether every check ran without errors.
+func (r Report) Complete() bool {
+	for _, cr := range r.Results {
+		if !cr.Complete() {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Run runs every Instance's checks concurrently. Results are ordered by
+// instance, then by check, in the order they were given.
+func (s *Service) Run(ctx context.Context, instances ...Instance) Report {
+	report := Report{GeneratedAt: time.Now().UTC()}
+
+	for _, instance := range instances {
+		for _, check := range instance.checks() {
+			report.Results = append(report.Results, CheckResult{
+				Label:       instance.Label,
+				DisplayName: instance.name(),
+				Check:       check,
+			})
+		}
+	}
+
+	var wg sync.WaitGroup
+	i := 0
+
+	for _, instance := range instances {
+		for _, check := range instance.checks() {
+			wg.Add(1)
+
+			go func(cr *CheckResult, instance Instance, check Check) {
+				defer wg.Done()
+				cr.Result = s.runCheck(ctx, instance, check)
+			}(&report.Results[i], instance, check)
+
+			i++
+		}
+	}
+
+	wg.Wait()
+
+	cfg := s.Config.withDefaults()
+	cfgs := make([]Config, 0, len(instances))
+
+	for _, instance := range instances {
+		for _, check := range instance.checks() {
+			if check == CheckIdleMachines {
+				cfgs = append(cfgs, instance.config(cfg))
+			}
+		}
+	}
+
+	report.Latencies = s.latencySamples(cfgs...)
+
+	return report
+}
+
+func (s *Service) runCheck(ctx context.Context, instance Instance, check Check) Result {
+	switch check {
+	case CheckOfflineNUCs:
+		return s.CheckOfflineNUCs(ctx, instance.Octopus, instance.Projects...)
+	case CheckIdleMachines:
+		return s.checkIdleMachines(ctx, instance.Octopus, instance.config(s.Config.withDefaults()), instance.Projects...)
+	case CheckDegradedMachines:
+		return s.CheckDegradedMachines(ctx, instance.Octopus, instance.Projects...)
+	case CheckDeployments:
+		return s.CheckDeployments(ctx, instance.Octopus, instance.Projects...)
+	case CheckUAIDMapping:
+		return s.checkUAIDMapping(ctx, instance.Octopus, instance.config(s.Config.withDefaults()), instance.Projects...)
+	default:
+		var result Result
+		result.addError("cdc", "", fmt.Errorf("unknown check %q", check))
+		return result
+	}
+}
@@ -1,8 +1,11 @@
 package cdc
 
 import (
+	"context"
+	"errors"
 	"fmt"
-	"sync"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/michaelmosher/monitoring/pkg/metricly"
@@ -10,126 +13,279 @@ import (
 )
 
 type metriclyClient interface {
-	FetchMetrics(metricly.MetricQuery) ([]metricly.Metric, error)
-	FetchMetricValue(metric metricly.Metric) (float64, error)
+	FetchMetrics(ctx context.Context, query metricly.MetricQuery, limit int) ([]metricly.Metric, error)
+	FetchMetricValue(ctx context.Context, metric metricly.Metric) (float64, error)
+	FetchMetricSamples(ctx context.Context, metric metricly.Metric, query metricly.SampleQuery) ([]metricly.Sample, error)
+	FetchMetricValues(ctx context.Context, metrics []metricly.Metric) (map[metricly.Metric]float64, error)
+	FetchBatchSamples(ctx context.Context, metrics []metricly.Metric, query metricly.SampleQuery) (map[metricly.Metric][]metricly.Sample, error)
 }
 
 type octopusClient interface {
-	FetchMachines() ([]octopus.Machine, error)
-	FetchTenants() ([]octopus.Tenant, error)
-	FetchProject(string) (octopus.Project, error)
-	FetchEvents(filter map[string]string) ([]octopus.Event, error)
-}
-
-type metricCache struct {
-	justOnce     sync.Once
-	doneFetching sync.RWMutex
-	samples      map[string]float64
+	FetchMachines(ctx context.Context) ([]octopus.Machine, error)
+	FetchTenants(ctx context.Context) ([]octopus.Tenant, error)
+	FetchProject(ctx context.Context, projectID string) (octopus.Project, error)
+	FetchEvents(ctx context.Context, filter map[string]string, limit int) ([]octopus.Event, error)
+	FetchReleases(ctx context.Context, projectID string, limit int) ([]octopus.Release, error)
+	FetchDashboard(ctx context.Context, projectIDs []string) ([]octopus.DashboardItem, error)
 }
 
+// Service runs CDC health checks. Config may be left as its zero value to
+// use DefaultConfig. A Service caches Metricly samples for Config.SampleTTL,
+// so it may be reused across runs.
 type Service struct {
 	Metricly    metriclyClient
-	metricCache metricCache
+	Config      Config
+	sampleCache sampleCache
+	// noBatch is set once Metricly has refused a batch request.
+	noBatch int32
 }
 
-// CheckOfflineNUCs returns a slice of Tenant names.
-func (s *Service) CheckOfflineNUCs(octo octopusClient, projectNames ...string) (map[string]float64, error) {
-	offlineWithDurations := make(map[string]float64)
+// CheckOfflineNUCs reports CDC tenants whose NUC is Unavailable, with how long
+// it has been offline and Octopus's status summary. Disabled NUCs are ignored.
+func (s *Service) CheckOfflineNUCs(ctx context.Context, octo octopusClient, projectNames ...string) Result {
+	var result Result
+	cfg := s.Config.withDefaults()
 
-	offlineNUCs, err := getOfflineNUCs(octo)
+	offlineNUCs, err := getOfflineNUCs(ctx, octo, cfg.OfflineRoles)
 
 	if err != nil {
-		return nil, err
+		result.addError("octopus", "", err)
+		return result
 	}
 
-	tenants, err := getOctopusTenants(octo)
+	tenants, err := getOctopusTenants(ctx, octo)
 
 	if err != nil {
-		return nil, err
+		result.addError("octopus", "", err)
+		return result
 	}
 
-	projects, err := getOctopusProjectIDs(octo, projectNames...)
+	projects, err := getOctopusProjectIDs(ctx, octo, projectNames...)
 
 	if err != nil {
-		return nil, err
+		result.addError("octopus", "", err)
+		return result
 	}
 
 	for _, nuc := range offlineNUCs {
-		offline := false
-
 		for id := range nuc.TenantIDs {
 			tenant := tenants[id]
-			for _, p := range projects {
-				if _, ok := tenant.ProjectIDs[p]; ok == true {
-					offline = true
-				}
+
+			if len(tenantProjects(tenant, projects)) == 0 {
+				continue
 			}
 
-			if offline {
-				event, err := getLatestOfflineEvent(octo, nuc)
-				if err != nil {
-					return nil, err
-				}
+			result.addObservation(Observation{Tenant: tenant.Name, Machine: nuc.Name, Status: nuc.Status, StatusSummary: nuc.StatusSummary})
 
-				offlineWithDurations[tenant.Name] = time.Now().Sub(event.Occurred).Hours()
+			event, err := getLatestOfflineEvent(ctx, octo, nuc)
+			if err != nil {
+				result.addError("octopus", tenant.Name, err)
+				continue
 			}
+
+			result.addFinding(tenant.Name, time.Since(event.Occurred), SeverityCritical, nuc.StatusSummary)
 		}
 	}
 
-	return offlineWithDurations, nil
+	result.sort()
+	return result
 }
 
-// CheckIdleMachines returns a slice of Tenant names.
-func (s *Service) CheckIdleMachines(octo octopusClient, projectNames ...string) (map[string]float64, error) {
-	idle := make(map[string]float64)
+// CheckIdleMachines reports CDC tenants whose machines are online but whose
+// HVR replication latency is too high, with the current latency. Disabled
+// machines are ignored. A tenant that can't be mapped to a latency metric is
+// an Error with the reason (see CheckUAIDMapping), as its latency is unknown.
+// Latency comes from Config.HubElement; use Run with an Instance.HubElement
+// to check another hub.
+func (s *Service) CheckIdleMachines(ctx context.Context, octo octopusClient, projectNames ...string) Result {
+	return s.checkIdleMachines(ctx, octo, s.Config.withDefaults(), projectNames...)
+}
+
+func (s *Service) checkIdleMachines(ctx context.Context, octo octopusClient, cfg Config, projectNames ...string) Result {
+	var result Result
 
-	onlineMachines, err := getOnlineMachines(octo)
+	onlineMachines, err := getOnlineMachines(ctx, octo, cfg.IdleRoles)
 
 	if err != nil {
-		return nil, err
+		result.addError("octopus", "", err)
+		return result
 	}
 
-	tenants, err := getOctopusTenants(octo)
+	tenants, err := getOctopusTenants(ctx, octo)
 
 	if err != nil {
-		return nil, err
+		result.addError("octopus", "", err)
+		return result
 	}
 
-	projects, err := getOctopusProjectIDs(octo, projectNames...)
+	projects, err := getOctopusProjectIDs(ctx, octo, projectNames...)
 
 	if err != nil {
-		return nil, err
+		result.addError("octopus", "", err)
+		return result
 	}
 
-	s.metricCache.justOnce.Do(func() {
-		s.metricCache.doneFetching.Lock()
-		samples, err := s.getMetriclySamples()
+	samples := s.getSampleSet(ctx, cfg)
+
+	if samples.err != nil {
+		result.addError("metricly", "", samples.err)
+		return result
+	}
 
-		if err != nil {
-			fmt.Printf("error getting Metricly Samples: %s\n", err)
+	mapping := newUAIDMapping(cfg, cdcTenants(tenants, projects), samples)
+
+	// a tenant may have several machines, so gather all of their roles first
+	tenantRoles := make(map[string]map[string]struct{})
+	tenantMachines := make(map[string][]octopus.Machine)
+
+	for _, machine := range onlineMachines {
+		for id := range machine.TenantIDs {
+			if _, ok := tenantRoles[id]; !ok {
+				tenantRoles[id] = make(map[string]struct{})
+			}
+
+			for role := range machine.Roles {
+				tenantRoles[id][role] = struct{}{}
+			}
+
+			tenantMachines[id] = append(tenantMachines[id], machine)
 		}
-		s.metricCache.samples = samples
-		s.metricCache.doneFetching.Unlock()
-	})
+	}
 
-	for _, nuc := range onlineMachines {
-		for id := range nuc.TenantIDs {
-			tenant := tenants[id]
+	for id, roles := range tenantRoles {
+		tenant := tenants[id]
+		memberOf := tenantProjects(tenant, projects)
+
+		if len(memberOf) == 0 {
+			continue
+		}
+
+		uaid, mapped := mapping.uaids[id]
+
+		// an unmapped tenant's latency is unknown rather than zero
+		if !mapped {
+			uaid, _ = tenantUAID(tenant)
+			result.addError("uaid", tenant.Name, errors.New(mapping.reason(tenant.Name)))
+		}
+
+		err, failed := samples.failures[uaid]
+
+		if failed {
+			result.addError("metricly", tenant.Name, err)
+		}
+
+		if !mapped || failed {
+			for _, machine := range tenantMachines[id] {
+				result.addObservation(Observation{
+					Tenant:        tenant.Name,
+					Machine:       machine.Name,
+					Status:        machine.Status,
+					StatusSummary: machine.StatusSummary,
+					UAID:          string(uaid),
+				})
+			}
+
+			continue
+		}
+
+		latency := time.Duration(samples.samples[uaid] * float64(time.Second))
+
+		for _, machine := range tenantMachines[id] {
+			result.addObservation(Observation{
+				Tenant:        tenant.Name,
+				Machine:       machine.Name,
+				Status:        machine.Status,
+				StatusSummary: machine.StatusSummary,
+				UAID:          string(uaid),
+				Latency:       latency,
+				Sampled:       true,
+			})
+		}
 
-			s.metricCache.doneFetching.RLock()
-			latency := s.metricCache.samples[tenant.Variables["UAID"]]
-			s.metricCache.doneFetching.RUnlock()
-
-			if latency > 600 {
-				for _, p := range projects {
-					if _, ok := tenant.ProjectIDs[p]; ok == true {
-						idle[tenant.Name] = latency
-						break
-					}
-				}
+		thresholds := cfg.latencyThresholds(roles, memberOf)
+
+		severity, unhealthy := thresholds.severity(latency)
+
+		if cfg.Sustained.enabled() {
+			severity, unhealthy = thresholds.sustainedSeverity(samples.series[uaid], cfg.Sustained)
+		}
+
+		if unhealthy {
+			result.addFinding(tenant.Name, latency, severity, "")
+		}
+	}
+
+	result.sort()
+	return result
+}
+
+// CheckDegradedMachines reports CDC tenants with a machine that Octopus can
+// reach, but whose last health check had warnings (a warning) or failed (a
+// critical finding). The reason lists each such machine's status summary.
+// These findings have no duration, since Octopus doesn't say how long a
+// machine has been degraded.
+func (s *Service) CheckDegradedMachines(ctx context.Context, octo octopusClient, projectNames ...string) Result {
+	var result Result
+	cfg := s.Config.withDefaults()
+
+	degradedMachines, err := getDegradedMachines(ctx, octo, cfg.DegradedRoles)
+
+	if err != nil {
+		result.addError("octopus", "", err)
+		return result
+	}
+
+	tenants, err := getOctopusTenants(ctx, octo)
+
+	if err != nil {
+		result.addError("octopus", "", err)
+		return result
+	}
+
+	projects, err := getOctopusProjectIDs(ctx, octo, projectNames...)
+
+	if err != nil {
+		result.addError("octopus", "", err)
+		return result
+	}
+
+	tenantMachines := make(map[string][]octopus.Machine)
+
+	for _, machine := range degradedMachines {
+		for id := range machine.TenantIDs {
+			tenantMachines[id] = append(tenantMachines[id], machine)
+		}
+	}
+
+	for id, machines := range tenantMachines {
+		tenant := tenants[id]
+
+		if len(tenantProjects(tenant, projects)) == 0 {
+			continue
+		}
+
+		severity := SeverityWarning
+		reasons := make([]string, 0, len(machines))
+
+		for _, machine := range machines {
+			result.addObservation(Observation{
+				Tenant:        tenant.Name,
+				Machine:       machine.Name,
+				Status:        machine.Status,
+				StatusSummary: machine.StatusSummary,
+			})
+
+			if machine.Status == octopus.HealthUnhealthy {
+				severity = SeverityCritical
 			}
+
+			reasons = append(reasons, fmt.Sprintf("%s is %s: %s", machine.Name, machine.Status, machine.StatusSummary))
 		}
+
+		sort.Strings(reasons)
+		result.addFinding(tenant.Name, 0, severity, strings.Join(reasons, "; "))
 	}
 
-	return idle, nil
+	result.sort()
+	return result
 }
@@ -1,52 +1,228 @@
 package cdc
 
 import (
+	"context"
 	"fmt"
-	"log"
-	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/michaelmosher/monitoring/pkg/metricly"
 )
 
-const metriclyMaxResults = 100
-const metriclyWorkers = 8
+const metriclyPageSize = 100
 
 type metriclyStatus struct {
-	uaid   string
+	metric metricly.Metric
 	sample float64
+	series []metricly.Sample
+	err    error
 }
 
-func getMetriclyList(service metriclyClient) ([]metricly.Metric, error) {
+func getMetriclyList(ctx context.Context, service metriclyClient, cfg Config) ([]metricly.Metric, error) {
 	metricsQuery := new(metricly.MetricQuery).
-		SetStartDate(time.Now().Add(-1*time.Hour)).
+		SetStartDate(time.Now().Add(-cfg.SampleWindow)).
 		SetEndDate(time.Now()).
-		AddElement("prod-hvr-hub-asi-001").
-		AddMetric("hvr_latency").
+		AddElement(cfg.HubElement).
+		AddMetric(cfg.LatencyMetric).
 		SetSourceIncludes("fqn", "id", "element").
 		SetSort("fqn", "asc")
 
-	metricsQuery.PageSize = metriclyMaxResults
+	metricsQuery.PageSize = metriclyPageSize
 
-	return service.FetchMetrics(*metricsQuery)
+	return service.FetchMetrics(ctx, *metricsQuery, 0)
 }
 
-func getMetricStatus(service metriclyClient, metric metricly.Metric) (metriclyStatus, error) {
-	val, err := service.FetchMetricValue(metric)
+func getMetricStatus(ctx context.Context, service metriclyClient, metric metricly.Metric) metriclyStatus {
+	val, err := service.FetchMetricValue(ctx, metric)
+
+	if err != nil {
+		err = fmt.Errorf("metricly.FetchMetricValue(%s) error: %s", metric.FQN, err)
+	}
 
 	return metriclyStatus{
-		uaid:   getUAIDFromFQN(metric.FQN),
+		metric: metric,
 		sample: val,
-	}, err
+		err:    err,
+	}
+}
+
+// getMetricSeries is like getMetricStatus, but fetches the latency history
+// needed to tell a sustained problem from a spike. sample is the latest value.
+func getMetricSeries(ctx context.Context, service metriclyClient, metric metricly.Metric, sustained Sustained) metriclyStatus {
+	status := metriclyStatus{metric: metric}
+
+	series, err := service.FetchMetricSamples(ctx, metric, sustained.sampleQuery())
+
+	switch {
+	case err != nil:
+		status.err = fmt.Errorf("metricly.FetchMetricSamples(%s) error: %s", metric.FQN, err)
+	case len(series) == 0:
+		status.err = fmt.Errorf("metricly.FetchMetricSamples(%s) error: no samples in the last %s", metric.FQN, sustained.Window)
+	default:
+		status.series = series
+		status.sample = series[len(series)-1].Value
+	}
+
+	return status
+}
+
+// getMetriclySamples fetches the latest latency sample of every metric on
+// cfg.HubElement, and if cfg.Sustained is enabled, its latency history.
+// Samples are fetched in batches if Metricly supports it, and one metric at a
+// time otherwise, or if a batch fails. Metrics with a malformed FQN aren't
+// fetched.
+func (s *Service) getMetriclySamples(ctx context.Context, cfg Config) sampleSet {
+	metrics, err := getMetriclyList(ctx, s.Metricly, cfg)
+
+	if err != nil {
+		return sampleSet{err: fmt.Errorf("metricly.FetchMetrics error: %s", err)}
+	}
+
+	set := sampleSet{
+		samples:   make(map[UAID]float64),
+		series:    make(map[UAID][]metricly.Sample),
+		failures:  make(map[UAID]error),
+		fqns:      make(map[UAID][]string),
+		malformed: make(map[string]error),
+	}
+
+	uaids := make(map[string]UAID, len(metrics))
+	valid := make([]metricly.Metric, 0, len(metrics))
+
+	for _, metric := range metrics {
+		uaid, err := UAIDFromFQN(metric.FQN)
+
+		if err != nil {
+			set.malformed[metric.FQN] = err
+			continue
+		}
+
+		uaids[metric.FQN] = uaid
+		set.fqns[uaid] = append(set.fqns[uaid], metric.FQN)
+		valid = append(valid, metric)
+	}
+
+	statuses, err := s.getBatchStatuses(ctx, cfg, valid)
+
+	if err != nil {
+		statuses, err = s.getPooledStatuses(ctx, cfg, valid)
+	}
+
+	if err != nil {
+		return sampleSet{err: err}
+	}
+
+	for _, status := range statuses {
+		uaid := uaids[status.metric.FQN]
+
+		if status.err != nil {
+			set.failures[uaid] = status.err
+			continue
+		}
+
+		set.samples[uaid] = status.sample
+
+		if status.series != nil {
+			set.series[uaid] = status.series
+		}
+	}
+
+	return set
 }
 
-func getUAIDFromFQN(fqn string) string {
-	return strings.ToUpper(strings.Split(fqn, ".")[1])
+// getBatchStatuses fetches every metric's samples, cfg.SampleBatchSize metrics
+// per request. It returns metricly.ErrBatchUnsupported, without making any
+// requests, once Metricly has refused a batch.
+func (s *Service) getBatchStatuses(ctx context.Context, cfg Config, metrics []metricly.Metric) ([]metriclyStatus, error) {
+	if atomic.LoadInt32(&s.noBatch) == 1 {
+		return nil, metricly.ErrBatchUnsupported
+	}
+
+	statuses := make([]metriclyStatus, 0, len(metrics))
+
+	for start := 0; start < len(metrics); start += cfg.SampleBatchSize {
+		end := start + cfg.SampleBatchSize
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+
+		batch, err := s.getBatchStatus(ctx, cfg, metrics[start:end])
+
+		if err == metricly.ErrBatchUnsupported {
+			atomic.StoreInt32(&s.noBatch, 1)
+			return nil, err
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, batch...)
+	}
+
+	return statuses, nil
+}
+
+func (s *Service) getBatchStatus(ctx context.Context, cfg Config, metrics []metricly.Metric) ([]metriclyStatus, error) {
+	statuses := make([]metriclyStatus, 0, len(metrics))
+
+	if !cfg.Sustained.enabled() {
+		values, err := s.Metricly.FetchMetricValues(ctx, metrics)
+
+		if err == metricly.ErrBatchUnsupported {
+			return nil, err
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("metricly.FetchMetricValues error: %s", err)
+		}
+
+		for _, metric := range metrics {
+			status := metriclyStatus{metric: metric}
+
+			value, ok := values[metric]
+			if !ok {
+				status.err = fmt.Errorf("metricly.FetchMetricValues(%s) error: no recent sample", metric.FQN)
+			}
+
+			status.sample = value
+			statuses = append(statuses, status)
+		}
+
+		return statuses, nil
+	}
+
+	samples, err := s.Metricly.FetchBatchSamples(ctx, metrics, cfg.Sustained.sampleQuery())
+
+	if err == metricly.ErrBatchUnsupported {
+		return nil, err
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("metricly.FetchBatchSamples error: %s", err)
+	}
+
+	for _, metric := range metrics {
+		status := metriclyStatus{metric: metric}
+
+		if series := samples[metric]; len(series) > 0 {
+			status.series = series
+			status.sample = series[len(series)-1].Value
+		} else {
+			status.err = fmt.Errorf("metricly.FetchBatchSamples(%s) error: no samples in the last %s", metric.FQN, cfg.Sustained.Window)
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses, nil
 }
 
-func (s *Service) getMetriclySamples() (map[string]float64, error) {
-	statuses := make(map[string]float64)
+// getPooledStatuses fetches every metric's samples one request at a time,
+// using cfg.SampleWorkers concurrent requests.
+func (s *Service) getPooledStatuses(ctx context.Context, cfg Config, metrics []metricly.Metric) ([]metriclyStatus, error) {
+	statuses := make([]metriclyStatus, 0, len(metrics))
 	metricChan := make(chan metricly.Metric)
 	statusChan := make(chan metriclyStatus)
 	done := make(chan bool)
@@ -55,43 +231,148 @@ func (s *Service) getMetriclySamples() (map[string]float64, error) {
 
 	go func() {
 		for status := range statusChan {
-			statuses[status.uaid] = status.sample
+			statuses = append(statuses, status)
 		}
 		done <- true
 	}()
 
-	for i := 0; i < metriclyWorkers; i++ {
+	for i := 0; i < cfg.SampleWorkers; i++ {
 		workerWaitGroup.Add(1)
 		go func() {
 			defer workerWaitGroup.Done()
 
 			for metric := range metricChan {
-				status, err := getMetricStatus(s.Metricly, metric)
-				if err != nil {
-					log.Printf("metricly.FetchMetricValue(%s) error: %s", metric.FQN, err)
+				if cfg.Sustained.enabled() {
+					statusChan <- getMetricSeries(ctx, s.Metricly, metric, cfg.Sustained)
 					continue
 				}
 
-				statusChan <- status
+				statusChan <- getMetricStatus(ctx, s.Metricly, metric)
 			}
 		}()
 	}
 
-	metrics, err := getMetriclyList(s.Metricly)
-
-	if err != nil {
-		return nil, fmt.Errorf("metricly.FetchMetrics error: %s", err)
-	}
-
+send:
 	for _, metric := range metrics {
-		metricChan <- metric
+		select {
+		case metricChan <- metric:
+		case <-ctx.Done():
+			break send
+		}
 	}
 
+	// statuses is only complete once the workers and the collector are done
 	close(metricChan)
-
 	workerWaitGroup.Wait()
 	close(statusChan)
-
 	<-done
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
 	return statuses, nil
 }
+
+// sampleKey identifies a set of latency samples by the hub element they come
+// from and the query used to fetch them.
+type sampleKey struct {
+	element   string
+	metric    string
+	window    time.Duration
+	sustained Sustained
+}
+
+func newSampleKey(cfg Config) sampleKey {
+	return sampleKey{
+		element:   cfg.HubElement,
+		metric:    cfg.LatencyMetric,
+		window:    cfg.SampleWindow,
+		sustained: cfg.Sustained,
+	}
+}
+
+// sampleSet is the result of one getMetriclySamples call: the latest sample,
+// history or error per UAID, and the FQNs of the metrics behind them. Its maps
+// are shared between checks, so they must not be modified.
+type sampleSet struct {
+	samples   map[UAID]float64
+	series    map[UAID][]metricly.Sample
+	failures  map[UAID]error
+	fqns      map[UAID][]string
+	malformed map[string]error
+	err       error
+}
+
+type sampleEntry struct {
+	mu      sync.Mutex
+	set     sampleSet
+	fetched time.Time
+}
+
+// sampleCache holds the latest samples for each sampleKey. Its zero value is
+// ready to use.
+type sampleCache struct {
+	mu      sync.Mutex
+	entries map[sampleKey]*sampleEntry
+}
+
+func (c *sampleCache) entry(key sampleKey) *sampleEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[sampleKey]*sampleEntry)
+	}
+
+	e, ok := c.entries[key]
+	if !ok {
+		e = &sampleEntry{}
+		c.entries[key] = e
+	}
+
+	return e
+}
+
+// getSampleSet returns the latency samples from cfg.HubElement, fetching them
+// unless they were fetched less than cfg.SampleTTL ago. Checks that ask for
+// the same samples at the same time share one fetch. A fetch that fails
+// outright isn't cached, so the next check tries again.
+func (s *Service) getSampleSet(ctx context.Context, cfg Config) sampleSet {
+	e := s.sampleCache.entry(newSampleKey(cfg))
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.fetched.IsZero() && time.Since(e.fetched) < cfg.SampleTTL {
+		return e.set
+	}
+
+	set := s.getMetriclySamples(ctx, cfg)
+
+	if set.err == nil {
+		e.set = set
+		e.fetched = time.Now()
+	}
+
+	return set
+}
+
+// latencySamples returns a copy of the cached latency samples for each of the
+// given configs, ignoring any that haven't been fetched. If two hub elements
+// report the same UAID, the later config wins.
+func (s *Service) latencySamples(cfgs ...Config) map[string]float64 {
+	samples := make(map[string]float64)
+
+	for _, cfg := range cfgs {
+		e := s.sampleCache.entry(newSampleKey(cfg))
+
+		e.mu.Lock()
+		for uaid, sample := range e.set.samples {
+			samples[string(uaid)] = sample
+		}
+		e.mu.Unlock()
+	}
+
+	return samples
+}
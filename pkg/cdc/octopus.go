@@ -1,32 +1,27 @@
 package cdc
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/michaelmosher/monitoring/pkg/octopus"
 )
 
-const (
-	nucOctopusRole = "side-server-appliances"
-	vmOctopusRole  = "linux-server"
-	dbOctopusRole  = "sql-server"
-)
-
-func getOfflineNUCs(octo octopusClient) ([]octopus.Machine, error) {
+func getOfflineNUCs(ctx context.Context, octo octopusClient, roles []string) ([]octopus.Machine, error) {
 	offlineNUCs := []octopus.Machine{}
 
-	allMachines, err := octo.FetchMachines()
+	allMachines, err := octo.FetchMachines(ctx)
 
 	if err != nil {
 		return nil, fmt.Errorf("octopus.FetchMachines error: %s", err)
 	}
 
 	for _, machine := range allMachines {
-		if machine.Status != "Unavailable" {
+		if !machine.Status.IsUnavailable() || machine.IsDisabled {
 			continue
 		}
 
-		if _, ok := machine.Roles[nucOctopusRole]; ok == false {
+		if !hasAnyRole(machine.Roles, roles) {
 			continue
 		}
 
@@ -36,7 +31,7 @@ func getOfflineNUCs(octo octopusClient) ([]octopus.Machine, error) {
 	return offlineNUCs, nil
 }
 
-func getLatestOfflineEvent(octo octopusClient, machine octopus.Machine) (octopus.Event, error) {
+func getLatestOfflineEvent(ctx context.Context, octo octopusClient, machine octopus.Machine) (octopus.Event, error) {
 	filter := map[string]string{
 		"regarding": machine.ID,
 		"groups":    "MachineCritical",
@@ -44,7 +39,7 @@ func getLatestOfflineEvent(octo octopusClient, machine octopus.Machine) (octopus
 	}
 
 	nullEvent := octopus.Event{}
-	events, err := octo.FetchEvents(filter)
+	events, err := octo.FetchEvents(ctx, filter, 1)
 
 	if err != nil {
 		return nullEvent, fmt.Errorf("octopus.FetchEvents error: %s", err)
@@ -57,25 +52,21 @@ func getLatestOfflineEvent(octo octopusClient, machine octopus.Machine) (octopus
 	return events[0], nil
 }
 
-func getOnlineMachines(octo octopusClient) ([]octopus.Machine, error) {
+func getOnlineMachines(ctx context.Context, octo octopusClient, roles []string) ([]octopus.Machine, error) {
 	onlineNUCs := []octopus.Machine{}
 
-	allMachines, err := octo.FetchMachines()
+	allMachines, err := octo.FetchMachines(ctx)
 
 	if err != nil {
 		return nil, fmt.Errorf("octopus.FetchMachines error: %s", err)
 	}
 
 	for _, machine := range allMachines {
-		if machine.Status == "Offline" {
+		if !machine.Status.IsReachable() || machine.IsDisabled {
 			continue
 		}
 
-		_, sideServer := machine.Roles[nucOctopusRole]
-		_, linuxServer := machine.Roles[vmOctopusRole]
-		_, sqlServer := machine.Roles[dbOctopusRole]
-
-		if !sideServer && !linuxServer && !sqlServer {
+		if !hasAnyRole(machine.Roles, roles) {
 			continue
 		}
 
@@ -85,10 +76,34 @@ func getOnlineMachines(octo octopusClient) ([]octopus.Machine, error) {
 	return onlineNUCs, nil
 }
 
-func getOctopusTenants(octo octopusClient) (map[string]octopus.Tenant, error) {
+func getDegradedMachines(ctx context.Context, octo octopusClient, roles []string) ([]octopus.Machine, error) {
+	degraded := []octopus.Machine{}
+
+	allMachines, err := octo.FetchMachines(ctx)
+
+	if err != nil {
+		return nil, fmt.Errorf("octopus.FetchMachines error: %s", err)
+	}
+
+	for _, machine := range allMachines {
+		if !machine.Status.IsDegraded() || machine.IsDisabled {
+			continue
+		}
+
+		if !hasAnyRole(machine.Roles, roles) {
+			continue
+		}
+
+		degraded = append(degraded, machine)
+	}
+
+	return degraded, nil
+}
+
+func getOctopusTenants(ctx context.Context, octo octopusClient) (map[string]octopus.Tenant, error) {
 	tm := make(map[string]octopus.Tenant)
 
-	tenants, err := octo.FetchTenants()
+	tenants, err := octo.FetchTenants(ctx)
 
 	if err != nil {
 		return nil, fmt.Errorf("octopus.FetchTenants error: %s", err)
@@ -101,18 +116,33 @@ func getOctopusTenants(octo octopusClient) (map[string]octopus.Tenant, error) {
 	return tm, nil
 }
 
-func getOctopusProjectIDs(octo octopusClient, projectNames ...string) ([]string, error) {
-	projectIDs := make([]string, 0, len(projectNames))
+// getOctopusProjectIDs returns a map of project ID to project name.
+func getOctopusProjectIDs(ctx context.Context, octo octopusClient, projectNames ...string) (map[string]string, error) {
+	projectIDs := make(map[string]string, len(projectNames))
 
 	for _, name := range projectNames {
-		project, err := octo.FetchProject(name)
+		project, err := octo.FetchProject(ctx, name)
 
 		if err != nil {
 			return nil, fmt.Errorf("octopus.FetchProject(%s) error: %s", name, err)
 		}
 
-		projectIDs = append(projectIDs, project.ID)
+		projectIDs[project.ID] = name
 	}
 
 	return projectIDs, nil
 }
+
+// tenantProjects returns the subset of projects (ID -> name) that tenant
+// belongs to.
+func tenantProjects(tenant octopus.Tenant, projects map[string]string) map[string]string {
+	memberOf := make(map[string]string)
+
+	for id, name := range projects {
+		if _, ok := tenant.ProjectIDs[id]; ok {
+			memberOf[id] = name
+		}
+	}
+
+	return memberOf
+}
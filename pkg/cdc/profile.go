@@ -0,0 +1,173 @@
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/octopus"
+)
+
+// octopusTenantClient is an octopusClient that can also look up a single
+// tenant.
+type octopusTenantClient interface {
+	octopusClient
+	FetchTenant(ctx context.Context, tenantID string) (octopus.Tenant, error)
+}
+
+// MachineProfile is one of a tenant's machines, with its most recent
+// MachineCritical events, newest first.
+type MachineProfile struct {
+	octopus.Machine
+	Events    []octopus.Event
+	EventsErr error
+}
+
+// Profile is everything known about a tenant on one Octopus instance.
+// Latency is only meaningful if LatencyErr is nil; it is the tenant's
+// current HVR latency sample, looked up by its UAID variable.
+type Profile struct {
+	Instance    string
+	Tenant      octopus.Tenant
+	CDCProjects []string
+	UAID        string
+	Machines    []MachineProfile
+	Latency     time.Duration
+	LatencyErr  error
+}
+
+// ErrTenantNotFound is returned by TenantProfile for a tenant that doesn't
+// exist on the instance.
+var ErrTenantNotFound = fmt.Errorf("tenant not found")
+
+// TenantProfile gathers a tenant's Octopus details, machines, recent
+// MachineCritical events (at most events per machine) and HVR latency.
+// nameOrID may be the tenant's ID, or its name in any case.
+func (s *Service) TenantProfile(ctx context.Context, instance Instance, nameOrID string, events int) (Profile, error) {
+	profile := Profile{Instance: instance.name()}
+	cfg := instance.config(s.Config.withDefaults())
+
+	octo, ok := instance.Octopus.(octopusTenantClient)
+	if !ok {
+		return profile, fmt.Errorf("the Octopus client for %s can't look up tenants", instance.Label)
+	}
+
+	tenants, err := getOctopusTenants(ctx, octo)
+
+	if err != nil {
+		return profile, err
+	}
+
+	var match octopus.Tenant
+
+	for _, tenant := range tenants {
+		if tenant.ID == nameOrID || strings.EqualFold(tenant.Name, nameOrID) {
+			match = tenant
+			break
+		}
+	}
+
+	if match.ID == "" {
+		return profile, ErrTenantNotFound
+	}
+
+	tenant, err := octo.FetchTenant(ctx, match.ID)
+
+	if err != nil {
+		return profile, fmt.Errorf("octopus.FetchTenant(%s) error: %s", match.ID, err)
+	}
+
+	// only tenantvariables has the library variables
+	tenant.Variables = match.Variables
+	profile.Tenant = tenant
+	profile.UAID = tenant.Variables[uaidVariable]
+
+	projects, err := getOctopusProjectIDs(ctx, octo, instance.Projects...)
+
+	if err != nil {
+		return profile, err
+	}
+
+	for _, name := range tenantProjects(tenant, projects) {
+		profile.CDCProjects = append(profile.CDCProjects, name)
+	}
+
+	sort.Strings(profile.CDCProjects)
+
+	machines, err := octo.FetchMachines(ctx)
+
+	if err != nil {
+		return profile, fmt.Errorf("octopus.FetchMachines error: %s", err)
+	}
+
+	for _, machine := range machines {
+		if _, ok := machine.TenantIDs[tenant.ID]; !ok {
+			continue
+		}
+
+		mp := MachineProfile{Machine: machine}
+		mp.Events, mp.EventsErr = getCriticalEvents(ctx, octo, machine, events)
+		profile.Machines = append(profile.Machines, mp)
+	}
+
+	sort.Slice(profile.Machines, func(i, j int) bool {
+		return profile.Machines[i].Name < profile.Machines[j].Name
+	})
+
+	profile.Latency, profile.LatencyErr = s.currentLatency(ctx, cfg, tenant)
+
+	return profile, nil
+}
+
+func getCriticalEvents(ctx context.Context, octo octopusClient, machine octopus.Machine, limit int) ([]octopus.Event, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	filter := map[string]string{
+		"regarding": machine.ID,
+		"groups":    "MachineCritical",
+		"take":      fmt.Sprintf("%d", limit),
+	}
+
+	events, err := octo.FetchEvents(ctx, filter, limit)
+
+	if err != nil {
+		return nil, fmt.Errorf("octopus.FetchEvents error: %s", err)
+	}
+
+	return events, nil
+}
+
+// currentLatency looks up the latest HVR latency sample for a tenant's UAID.
+func (s *Service) currentLatency(ctx context.Context, cfg Config, tenant octopus.Tenant) (time.Duration, error) {
+	uaid, err := tenantUAID(tenant)
+
+	if err != nil {
+		return 0, err
+	}
+
+	metrics, err := getMetriclyList(ctx, s.Metricly, cfg)
+
+	if err != nil {
+		return 0, fmt.Errorf("metricly.FetchMetrics error: %s", err)
+	}
+
+	for _, metric := range metrics {
+		if fqnUAID, err := UAIDFromFQN(metric.FQN); err != nil || fqnUAID != uaid {
+			continue
+		}
+
+		status := getMetricStatus(ctx, s.Metricly, metric)
+
+		if status.err != nil {
+			return 0, status.err
+		}
+
+		return time.Duration(status.sample * float64(time.Second)), nil
+	}
+
+	return 0, fmt.Errorf("no %s metric for UAID %s", cfg.LatencyMetric, uaid)
+}
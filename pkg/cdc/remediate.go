@@ -0,0 +1,127 @@
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/octopus"
+)
+
+const taskPollInterval = 5 * time.Second
+
+// octopusTaskClient is an octopusClient that can also run health checks.
+type octopusTaskClient interface {
+	octopusClient
+	FetchMachine(ctx context.Context, machineID string) (octopus.Machine, error)
+	CreateHealthCheckTask(ctx context.Context, machineIDs []string) (octopus.Task, error)
+	WaitForTask(ctx context.Context, taskID string, interval time.Duration) (octopus.Task, error)
+}
+
+// Recheck is a NUC that CheckOfflineNUCs would report, and its status after
+// a fresh health check. Status is empty if the health check wasn't run (a
+// dry run) or failed, in which case Err says why.
+type Recheck struct {
+	Machine octopus.Machine
+	Tenants []string
+	Task    octopus.Task
+	Status  octopus.HealthStatus
+	Err     error
+}
+
+// Recovered reports whether the health check found the NUC reachable again.
+func (r Recheck) Recovered() bool {
+	return r.Status.IsReachable()
+}
+
+// RecheckOfflineNUCs runs a single Octopus health check of every CDC NUC
+// that CheckOfflineNUCs would report for instance, waiting up to timeout for
+// it to finish, so that a NUC that has since come back isn't escalated. If
+// dryRun is true, it only returns the NUCs that would be checked.
+func (s *Service) RecheckOfflineNUCs(ctx context.Context, instance Instance, timeout time.Duration, dryRun bool) ([]Recheck, error) {
+	octo, ok := instance.Octopus.(octopusTaskClient)
+	if !ok {
+		return nil, fmt.Errorf("the Octopus client for %s can't run health checks", instance.Label)
+	}
+
+	cfg := s.Config.withDefaults()
+
+	offlineNUCs, err := getOfflineNUCs(ctx, octo, cfg.OfflineRoles)
+
+	if err != nil {
+		return nil, err
+	}
+
+	tenants, err := getOctopusTenants(ctx, octo)
+
+	if err != nil {
+		return nil, err
+	}
+
+	projects, err := getOctopusProjectIDs(ctx, octo, instance.Projects...)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rechecks := []Recheck{}
+	machineIDs := []string{}
+
+	for _, nuc := range offlineNUCs {
+		recheck := Recheck{Machine: nuc}
+
+		for id := range nuc.TenantIDs {
+			if tenant := tenants[id]; len(tenantProjects(tenant, projects)) > 0 {
+				recheck.Tenants = append(recheck.Tenants, tenant.Name)
+			}
+		}
+
+		if len(recheck.Tenants) == 0 {
+			continue
+		}
+
+		sort.Strings(recheck.Tenants)
+		rechecks = append(rechecks, recheck)
+		machineIDs = append(machineIDs, nuc.ID)
+	}
+
+	sort.Slice(rechecks, func(i, j int) bool {
+		return rechecks[i].Machine.Name < rechecks[j].Machine.Name
+	})
+
+	if dryRun || len(rechecks) == 0 {
+		return rechecks, nil
+	}
+
+	task, err := octo.CreateHealthCheckTask(ctx, machineIDs)
+
+	if err != nil {
+		return nil, fmt.Errorf("octopus.CreateHealthCheckTask error: %s", err)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	task, err = octo.WaitForTask(waitCtx, task.ID, taskPollInterval)
+
+	for i := range rechecks {
+		rechecks[i].Task = task
+
+		if err != nil {
+			rechecks[i].Err = fmt.Errorf("octopus.WaitForTask error: %s", err)
+			continue
+		}
+
+		machine, err := octo.FetchMachine(ctx, rechecks[i].Machine.ID)
+
+		if err != nil {
+			rechecks[i].Err = fmt.Errorf("octopus.FetchMachine error: %s", err)
+			continue
+		}
+
+		rechecks[i].Status = machine.Status
+	}
+
+	return rechecks, nil
+}
@@ -0,0 +1,91 @@
+package cdc
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/octopus"
+)
+
+// Finding is a single unhealthy tenant, how long it has been unhealthy, and
+// how much that matters. Reason is Octopus's explanation, if it has one.
+// CheckUAIDMapping findings about a Metricly metric have its FQN as Tenant.
+type Finding struct {
+	Tenant   string
+	Duration time.Duration
+	Severity Severity
+	Reason   string
+}
+
+// Error describes data that a check could not retrieve. Tenant is empty when
+// a whole source failed (e.g. the Octopus API was unreachable), in which case
+// the check's findings are incomplete for every tenant.
+type Error struct {
+	Source string
+	Tenant string
+	Err    error
+}
+
+func (e Error) Error() string {
+	if e.Tenant == "" {
+		return fmt.Sprintf("%s: %s", e.Source, e.Err)
+	}
+
+	return fmt.Sprintf("%s (%s): %s", e.Source, e.Tenant, e.Err)
+}
+
+// Observation is the state of one of a tenant's machines when a check ran,
+// whether or not it was found to be unhealthy. Latency is only meaningful if
+// Sampled is true.
+type Observation struct {
+	Tenant        string
+	Machine       string
+	Status        octopus.HealthStatus
+	StatusSummary string
+	UAID          string
+	Latency       time.Duration
+	Sampled       bool
+}
+
+// Result is the outcome of a check: what was found to be unhealthy, what
+// could not be determined, and everything the check looked at.
+type Result struct {
+	Findings     []Finding
+	Errors       []Error
+	Observations []Observation
+}
+
+// Complete reports whether the check ran without any errors, i.e. whether an
+// empty Findings list really means "all healthy".
+func (r Result) Complete() bool {
+	return len(r.Errors) == 0
+}
+
+func (r *Result) addFinding(tenant string, d time.Duration, severity Severity, reason string) {
+	r.Findings = append(r.Findings, Finding{Tenant: tenant, Duration: d, Severity: severity, Reason: reason})
+}
+
+func (r *Result) addError(source string, tenant string, err error) {
+	r.Errors = append(r.Errors, Error{Source: source, Tenant: tenant, Err: err})
+}
+
+func (r *Result) addObservation(o Observation) {
+	r.Observations = append(r.Observations, o)
+}
+
+func (r *Result) sort() {
+	sort.Slice(r.Findings, func(i, j int) bool {
+		a, b := r.Findings[i], r.Findings[j]
+		return a.Tenant < b.Tenant || (a.Tenant == b.Tenant && a.Reason < b.Reason)
+	})
+
+	sort.Slice(r.Errors, func(i, j int) bool {
+		return r.Errors[i].Error() < r.Errors[j].Error()
+	})
+
+	sort.Slice(r.Observations, func(i, j int) bool {
+		a, b := r.Observations[i], r.Observations[j]
+		return a.Tenant < b.Tenant || (a.Tenant == b.Tenant && a.Machine < b.Machine)
+	})
+}
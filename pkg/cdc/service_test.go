@@ -0,0 +1,266 @@
+package cdc_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+	"github.com/michaelmosher/monitoring/pkg/fake"
+	"github.com/michaelmosher/monitoring/pkg/metricly"
+	metricly_http "github.com/michaelmosher/monitoring/pkg/metricly/http"
+	"github.com/michaelmosher/monitoring/pkg/octopus"
+	octopus_http "github.com/michaelmosher/monitoring/pkg/octopus/http"
+)
+
+// wantFindings are the findings for the default fixtures, by check, as
+// "tenant (severity)".
+var wantFindings = map[cdc.Check][]string{
+	cdc.CheckOfflineNUCs:      {"Acme Health (critical)"},
+	cdc.CheckIdleMachines:     {"Bayside Clinic (critical)", "Cedar Hospital (critical)"},
+	cdc.CheckDegradedMachines: {"Bayside Clinic (warning)"},
+	cdc.CheckDeployments:      {"Bayside Clinic (critical)", "Cedar Hospital (warning)"},
+	cdc.CheckUAIDMapping:      {"hvr_latency_ua0005 (warning)"},
+}
+
+var wantLatencies = map[string]float64{"UA0001": 0, "UA0002": 1470, "UA0003": 1200, "UA0004": 15}
+
+// metriclyCounter counts the sample requests made to a fake Metricly, and can
+// fail every batch.
+type metriclyCounter struct {
+	fake.Metricly
+	failBatch bool
+	batch     int32
+	single    int32
+}
+
+func (m *metriclyCounter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	var query metricly.MetricQuery
+	json.Unmarshal(body, &query)
+
+	switch {
+	case includes(query.SourceFilter.Includes, "samples"):
+		atomic.AddInt32(&m.batch, 1)
+
+		if m.failBatch {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	case filepath.Base(r.URL.Path) == "samples":
+		atomic.AddInt32(&m.single, 1)
+	}
+
+	m.Metricly.ServeHTTP(w, r)
+}
+
+func includes(fields []string, field string) bool {
+	for _, f := range fields {
+		if f == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestServiceRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		batchSize int
+		noBatch   bool
+		failBatch bool
+		// wantBatch is whether samples should come from batch requests only.
+		wantBatch bool
+	}{
+		{"batched", 0, false, false, true},
+		{"small batches", 2, false, false, true},
+		{"batch refused", 0, true, false, false},
+		{"batch failed", 0, false, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			octo := fake.NewOctopus(fake.Fixtures())
+			defer octo.Close()
+
+			counter := &metriclyCounter{
+				Metricly: fake.Metricly{
+					Dir:     filepath.Join(fake.Fixtures(), "metricly"),
+					APIKey:  fake.MetriclyAPIKey,
+					NoBatch: tt.noBatch,
+				},
+				failBatch: tt.failBatch,
+			}
+
+			m := httptest.NewServer(counter)
+			defer m.Close()
+
+			service := &cdc.Service{
+				Metricly: metricly.New(metricly_http.New(m.Client(), m.URL, metricly_http.Credentials{APIKey: fake.MetriclyAPIKey})),
+				Config:   cdc.Config{SampleBatchSize: tt.batchSize, SampleTTL: time.Nanosecond},
+			}
+
+			instance := cdc.Instance{
+				Label:    "ASI",
+				Octopus:  octopus.New(octopus_http.New(octo.Client(), octo.URL, fake.OctopusSpace, fake.OctopusAPIKey)),
+				Projects: []string{"CDC Replication"},
+				Checks:   cdc.AllChecks,
+			}
+
+			report := service.Run(context.Background(), instance)
+
+			if !report.Complete() {
+				t.Errorf("got an incomplete report: %+v", report.Results)
+			}
+
+			if len(report.Results) != len(cdc.AllChecks) {
+				t.Fatalf("got %d results, want %d", len(report.Results), len(cdc.AllChecks))
+			}
+
+			for i, cr := range report.Results {
+				if cr.Check != cdc.AllChecks[i] || cr.Label != "ASI" {
+					t.Errorf("result %d is %s on %s, want %s on ASI", i, cr.Check, cr.Label, cdc.AllChecks[i])
+				}
+
+				got := make([]string, 0, len(cr.Findings))
+				for _, f := range cr.Findings {
+					got = append(got, fmt.Sprintf("%s (%s)", f.Tenant, f.Severity))
+				}
+
+				if want := wantFindings[cr.Check]; !reflect.DeepEqual(got, want) {
+					t.Errorf("%s findings: got %v, want %v", cr.Check, got, want)
+				}
+			}
+
+			if !reflect.DeepEqual(report.Latencies, wantLatencies) {
+				t.Errorf("got latencies %v, want %v", report.Latencies, wantLatencies)
+			}
+
+			batch, single := atomic.LoadInt32(&counter.batch), atomic.LoadInt32(&counter.single)
+
+			switch {
+			case tt.wantBatch && (batch == 0 || single != 0):
+				t.Errorf("made %d batch and %d single sample requests, want only batches", batch, single)
+			case !tt.wantBatch && (batch == 0 || single == 0):
+				t.Errorf("made %d batch and %d single sample requests, want a batch, then single requests", batch, single)
+			}
+
+			// a refused batch isn't tried again, but a failed one is
+			service.Run(context.Background(), instance)
+
+			again := atomic.LoadInt32(&counter.batch) - batch
+
+			switch {
+			case tt.noBatch && again != 0:
+				t.Errorf("made %d batch requests after Metricly refused one", again)
+			case !tt.noBatch && again == 0:
+				t.Errorf("made no batch requests in the second run")
+			}
+		})
+	}
+}
+
+// copyFixtures copies the default fixtures to a temporary directory, so that
+// a test can edit them.
+func copyFixtures(t *testing.T) string {
+	dir := t.TempDir()
+
+	for _, sub := range []string{"octopus", "metricly"} {
+		files, err := filepath.Glob(filepath.Join(fake.Fixtures(), sub, "*.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, file := range files {
+			data, err := ioutil.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := ioutil.WriteFile(filepath.Join(dir, sub, filepath.Base(file)), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	return dir
+}
+
+func TestServiceRunReportsUnmappedTenants(t *testing.T) {
+	dir := copyFixtures(t)
+	path := filepath.Join(dir, "octopus", "tenantvariables.json")
+
+	var tenants []map[string]interface{}
+
+	data, _ := ioutil.ReadFile(path)
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		t.Fatal(err)
+	}
+
+	// Bayside Clinic is idle, but without a UAID its latency is unknown
+	for _, tenant := range tenants {
+		if tenant["TenantName"] == "Bayside Clinic" {
+			delete(tenant, "LibraryVariables")
+		}
+	}
+
+	data, _ = json.Marshal(tenants)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	octo := fake.NewOctopus(dir)
+	defer octo.Close()
+
+	m := fake.NewMetricly(dir)
+	defer m.Close()
+
+	service := &cdc.Service{
+		Metricly: metricly.New(metricly_http.New(m.Client(), m.URL, metricly_http.Credentials{APIKey: fake.MetriclyAPIKey})),
+	}
+
+	report := service.Run(context.Background(), cdc.Instance{
+		Label:    "ASI",
+		Octopus:  octopus.New(octopus_http.New(octo.Client(), octo.URL, fake.OctopusSpace, fake.OctopusAPIKey)),
+		Projects: []string{"CDC Replication"},
+		Checks:   []cdc.Check{cdc.CheckIdleMachines},
+	})
+
+	result := report.Results[0]
+
+	got := make([]string, 0, len(result.Findings))
+	for _, f := range result.Findings {
+		got = append(got, f.Tenant)
+	}
+
+	if want := []string{"Cedar Hospital"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got findings for %v, want %v", got, want)
+	}
+
+	wantErrors := []string{"uaid (Bayside Clinic): no UAID variable"}
+	gotErrors := make([]string, 0, len(result.Errors))
+	for _, err := range result.Errors {
+		gotErrors = append(gotErrors, err.Error())
+	}
+
+	if !reflect.DeepEqual(gotErrors, wantErrors) {
+		t.Errorf("got errors %v, want %v", gotErrors, wantErrors)
+	}
+}
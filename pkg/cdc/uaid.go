@@ -0,0 +1,228 @@
+package cdc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/michaelmosher/monitoring/pkg/octopus"
+)
+
+// UAID identifies a CDC tenant's HVR replication. It links an Octopus
+// tenant, through its "UAID" variable, to the Metricly latency metric whose
+// FQN has the UAID as its second part, e.g. "hvr.ua0001.hvr_latency".
+type UAID string
+
+// uaidVariable is the Octopus tenant variable holding a tenant's UAID.
+const uaidVariable = "UAID"
+
+// ParseUAID validates and normalizes a UAID, which is case-insensitive and
+// made up of letters and digits.
+func ParseUAID(value string) (UAID, error) {
+	value = strings.TrimSpace(value)
+
+	if value == "" {
+		return "", fmt.Errorf("empty UAID")
+	}
+
+	for _, r := range value {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9') {
+			return "", fmt.Errorf("invalid UAID %q", value)
+		}
+	}
+
+	return UAID(strings.ToUpper(value)), nil
+}
+
+// UAIDFromFQN returns the UAID in a Metricly latency metric's FQN.
+func UAIDFromFQN(fqn string) (UAID, error) {
+	parts := strings.Split(fqn, ".")
+
+	if len(parts) < 2 {
+		return "", fmt.Errorf("malformed FQN %q: expected <prefix>.<UAID>...", fqn)
+	}
+
+	uaid, err := ParseUAID(parts[1])
+
+	if err != nil {
+		return "", fmt.Errorf("malformed FQN %q: %s", fqn, err)
+	}
+
+	return uaid, nil
+}
+
+// tenantUAID returns the UAID in a tenant's variables.
+func tenantUAID(tenant octopus.Tenant) (UAID, error) {
+	value, ok := tenant.Variables[uaidVariable]
+
+	if !ok || strings.TrimSpace(value) == "" {
+		return "", fmt.Errorf("no %s variable", uaidVariable)
+	}
+
+	uaid, err := ParseUAID(value)
+
+	if err != nil {
+		return "", fmt.Errorf("%s variable: %s", uaidVariable, err)
+	}
+
+	return uaid, nil
+}
+
+// uaidProblem is something that stops a tenant's latency from being checked.
+// Subject is the tenant's name, or a Metricly FQN if no tenant is involved.
+type uaidProblem struct {
+	subject  string
+	severity Severity
+	reason   string
+}
+
+// uaidMapping links CDC tenants to the latency metrics on one hub element.
+type uaidMapping struct {
+	// uaids holds, by tenant ID, the UAID of every tenant with a metric.
+	uaids map[string]UAID
+	// problems holds at most one uaidProblem per subject; bySubject is its
+	// index.
+	problems  []uaidProblem
+	bySubject map[string]int
+}
+
+// newUAIDMapping maps tenants to the metrics in set. It reports tenants
+// without a valid UAID, UAIDs shared by several tenants or metrics, and, if
+// set was fetched, UAIDs without a metric and malformed FQNs.
+func newUAIDMapping(cfg Config, tenants []octopus.Tenant, set sampleSet) uaidMapping {
+	m := uaidMapping{uaids: make(map[string]UAID), bySubject: make(map[string]int)}
+	owners := make(map[UAID][]string)
+
+	for _, tenant := range tenants {
+		uaid, err := tenantUAID(tenant)
+
+		if err != nil {
+			m.addProblem(tenant.Name, SeverityWarning, err.Error())
+			continue
+		}
+
+		owners[uaid] = append(owners[uaid], tenant.Name)
+
+		if set.err != nil {
+			continue
+		}
+
+		if _, ok := set.fqns[uaid]; !ok {
+			m.addProblem(tenant.Name, SeverityWarning, fmt.Sprintf("no %s metric on %s for UAID %s", cfg.LatencyMetric, cfg.HubElement, uaid))
+			continue
+		}
+
+		m.uaids[tenant.ID] = uaid
+	}
+
+	for uaid, names := range owners {
+		if len(names) < 2 {
+			continue
+		}
+
+		sort.Strings(names)
+
+		for _, name := range names {
+			m.addProblem(name, SeverityWarning, fmt.Sprintf("UAID %s is shared by %s", uaid, strings.Join(names, ", ")))
+		}
+	}
+
+	for uaid, fqns := range set.fqns {
+		if len(fqns) < 2 {
+			continue
+		}
+
+		fqns = append([]string(nil), fqns...)
+		sort.Strings(fqns)
+
+		for _, fqn := range fqns {
+			m.addProblem(fqn, SeverityWarning, fmt.Sprintf("UAID %s has %d metrics: %s", uaid, len(fqns), strings.Join(fqns, ", ")))
+		}
+	}
+
+	for fqn, err := range set.malformed {
+		m.addProblem(fqn, SeverityWarning, err.Error())
+	}
+
+	return m
+}
+
+// addProblem records a problem with subject. A subject with several problems
+// is reported once, with every reason.
+func (m *uaidMapping) addProblem(subject string, severity Severity, reason string) {
+	i, ok := m.bySubject[subject]
+
+	if !ok {
+		m.bySubject[subject] = len(m.problems)
+		m.problems = append(m.problems, uaidProblem{subject: subject, severity: severity, reason: reason})
+		return
+	}
+
+	m.problems[i].reason = fmt.Sprintf("%s; %s", m.problems[i].reason, reason)
+}
+
+// reason returns why subject couldn't be mapped, if it has a problem.
+func (m uaidMapping) reason(subject string) string {
+	if i, ok := m.bySubject[subject]; ok {
+		return m.problems[i].reason
+	}
+
+	return "no UAID mapping"
+}
+
+// cdcTenants returns the tenants that belong to at least one of projects.
+func cdcTenants(tenants map[string]octopus.Tenant, projects map[string]string) []octopus.Tenant {
+	members := make([]octopus.Tenant, 0, len(tenants))
+
+	for _, tenant := range tenants {
+		if len(tenantProjects(tenant, projects)) > 0 {
+			members = append(members, tenant)
+		}
+	}
+
+	return members
+}
+
+// CheckUAIDMapping reports problems linking CDC tenants to their HVR latency
+// metrics, any of which stops CheckIdleMachines from checking a tenant:
+// tenants without a valid UAID variable, UAIDs without a latency metric, UAIDs
+// shared by several tenants or metrics, and malformed metric FQNs. A finding
+// about a metric has its FQN in place of a tenant name. Latency comes from
+// Config.HubElement; use Run with an Instance.HubElement to check another hub.
+func (s *Service) CheckUAIDMapping(ctx context.Context, octo octopusClient, projectNames ...string) Result {
+	return s.checkUAIDMapping(ctx, octo, s.Config.withDefaults(), projectNames...)
+}
+
+func (s *Service) checkUAIDMapping(ctx context.Context, octo octopusClient, cfg Config, projectNames ...string) Result {
+	var result Result
+
+	tenants, err := getOctopusTenants(ctx, octo)
+
+	if err != nil {
+		result.addError("octopus", "", err)
+		return result
+	}
+
+	projects, err := getOctopusProjectIDs(ctx, octo, projectNames...)
+
+	if err != nil {
+		result.addError("octopus", "", err)
+		return result
+	}
+
+	samples := s.getSampleSet(ctx, cfg)
+
+	if samples.err != nil {
+		result.addError("metricly", "", samples.err)
+	}
+
+	mapping := newUAIDMapping(cfg, cdcTenants(tenants, projects), samples)
+
+	for _, p := range mapping.problems {
+		result.addFinding(p.subject, 0, p.severity, p.reason)
+	}
+
+	result.sort()
+	return result
+}
@@ -0,0 +1,46 @@
+// Package fake serves stand-ins for the Octopus and Metricly APIs, built on
+// httptest, so that the CDC checks can be run end-to-end without network
+// access. Responses are read from JSON fixture files on every request, so a
+// test can copy the default fixtures and edit them to set up a scenario.
+//
+//	octo := fake.NewOctopus(fake.Fixtures())
+//	defer octo.Close()
+//	client := octopus_http.New(octo.Client(), octo.URL, fake.OctopusSpace, fake.OctopusAPIKey)
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"runtime"
+)
+
+// Fixtures returns the directory holding the fixtures that ship with this
+// package. It has an "octopus" and a "metricly" subdirectory; see Octopus and
+// Metricly for the files each one reads.
+func Fixtures() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "fixtures")
+}
+
+func readFixture(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("error decoding %s: %v", path, err)
+	}
+
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
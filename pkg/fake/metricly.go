@@ -0,0 +1,365 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/metricly"
+)
+
+const (
+	// MetriclyUsername and MetriclyPassword, or MetriclyAPIKey, are the
+	// credentials accepted by NewMetricly.
+	MetriclyUsername = "fake"
+	MetriclyPassword = "fake"
+	MetriclyAPIKey   = "metricly-fake-key"
+)
+
+// Metricly serves the Metricly API endpoints used by the CDC checks from these
+// files in Dir:
+//
+//	metrics.json  metrics/elasticsearch/metricQuery, as a list of
+//	              {"id", "elementId", "fqn"} objects
+//	samples.json  elements/{element}/metrics/{id}/samples and (unless
+//	              NoBatch is set) the "samples" field of a metricQuery, as a
+//	              map of metric ID to a list of values, one per minute,
+//	              ending now
+//
+// Sample timestamps are relative to the time of the request, so fixtures
+// never go stale. Requests without the right basic auth or bearer token are
+// rejected.
+type Metricly struct {
+	Dir      string
+	Username string
+	Password string
+	APIKey   string
+	// NoBatch leaves the "samples" field out of metricQuery results, as if
+	// this were an older Metricly API.
+	NoBatch bool
+}
+
+// NewMetricly starts a fake Metricly server for MetriclyUsername and
+// MetriclyPassword, serving the fixtures in dir/metricly. Callers should Close
+// it when done.
+func NewMetricly(dir string) *httptest.Server {
+	return httptest.NewServer(Metricly{
+		Dir:      filepath.Join(dir, "metricly"),
+		Username: MetriclyUsername,
+		Password: MetriclyPassword,
+		APIKey:   MetriclyAPIKey,
+	})
+}
+
+type metriclyError struct {
+	Message string `json:"message"`
+}
+
+type metriclyMetric struct {
+	ID        string            `json:"id"`
+	ElementID string            `json:"elementId"`
+	FQN       string            `json:"fqn"`
+	Samples   *[]metriclySample `json:"samples,omitempty"`
+}
+
+var samplesPath = regexp.MustCompile(`^/elements/([^/]+)/metrics/([^/]+)/samples$`)
+
+func (m Metricly) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !m.authorized(r) {
+		writeJSON(w, http.StatusUnauthorized, metriclyError{"Bad credentials"})
+		return
+	}
+
+	if r.URL.Path == "/metrics/elasticsearch/metricQuery" && r.Method == "POST" {
+		m.serveMetrics(w, r)
+		return
+	}
+
+	if match := samplesPath.FindStringSubmatch(r.URL.Path); match != nil {
+		m.serveSamples(w, r, match[1], match[2])
+		return
+	}
+
+	writeJSON(w, http.StatusNotFound, metriclyError{fmt.Sprintf("No handler found for %s %s", r.Method, r.URL.Path)})
+}
+
+func (m Metricly) authorized(r *http.Request) bool {
+	if m.APIKey != "" && r.Header.Get("Authorization") == "Bearer "+m.APIKey {
+		return true
+	}
+
+	username, password, ok := r.BasicAuth()
+
+	return ok && username == m.Username && password == m.Password
+}
+
+// serveMetrics serves the metrics whose element ID and FQN contain one of the
+// query's element and metric items, a page at a time. If the query includes
+// the "samples" field, each metric has its raw samples between the query's
+// startDate and endDate.
+func (m Metricly) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	var query metricly.MetricQuery
+
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		writeJSON(w, http.StatusBadRequest, metriclyError{err.Error()})
+		return
+	}
+
+	var metrics []metriclyMetric
+
+	if err := readFixture(filepath.Join(m.Dir, "metrics.json"), &metrics); err != nil {
+		writeJSON(w, http.StatusInternalServerError, metriclyError{err.Error()})
+		return
+	}
+
+	elements := make([]string, 0, len(query.ElementFqns.Items))
+	for _, item := range query.ElementFqns.Items {
+		elements = append(elements, item.Item)
+	}
+
+	names := make([]string, 0, len(query.MetricFqns.Items))
+	for _, item := range query.MetricFqns.Items {
+		names = append(names, item.Item)
+	}
+
+	matched := []metriclyMetric{}
+
+	for _, metric := range metrics {
+		if containsAny(metric.ElementID, elements) && containsAny(metric.FQN, names) {
+			matched = append(matched, metric)
+		}
+	}
+
+	pageSize := query.PageSize
+	if pageSize <= 0 {
+		pageSize = 10
+	}
+
+	start := query.Page * pageSize
+	if start > len(matched) {
+		start = len(matched)
+	}
+
+	end := start + pageSize
+	if end > len(matched) {
+		end = len(matched)
+	}
+
+	page := matched[start:end]
+
+	if includesField(query, "samples") && !m.NoBatch {
+		if err := m.addSamples(page, query); err != nil {
+			writeJSON(w, http.StatusBadRequest, metriclyError{err.Error()})
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"page":             map[string]interface{}{"content": page},
+		"numberOfElements": end - start,
+		"last":             end == len(matched),
+	})
+}
+
+// addSamples sets the samples of each of metrics between the query's
+// startDate and endDate.
+func (m Metricly) addSamples(metrics []metriclyMetric, query metricly.MetricQuery) error {
+	var samples map[string][]float64
+
+	if err := readFixture(filepath.Join(m.Dir, "samples.json"), &samples); err != nil {
+		return err
+	}
+
+	startDate, err := time.Parse(time.RFC3339, query.StartDate)
+	if err != nil {
+		return fmt.Errorf("invalid startDate: %v", err)
+	}
+
+	endDate, err := time.Parse(time.RFC3339, query.EndDate)
+	if err != nil {
+		return fmt.Errorf("invalid endDate: %v", err)
+	}
+
+	for i := range metrics {
+		series := rollupSamples(samples[metrics[i].ID], endDate.Sub(startDate), time.Minute, "")
+		metrics[i].Samples = &series
+	}
+
+	return nil
+}
+
+type metriclySample struct {
+	Timestamp int64 `json:"timestamp"`
+	Data      struct {
+		Val float64 `json:"val"`
+	} `json:"data"`
+}
+
+// serveSamples serves the samples of a metric within the requested duration,
+// oldest first.
+func (m Metricly) serveSamples(w http.ResponseWriter, r *http.Request, elementID string, metricID string) {
+	var samples map[string][]float64
+
+	if err := readFixture(filepath.Join(m.Dir, "samples.json"), &samples); err != nil {
+		writeJSON(w, http.StatusInternalServerError, metriclyError{err.Error()})
+		return
+	}
+
+	values, ok := samples[metricID]
+	if !ok {
+		writeJSON(w, http.StatusNotFound, metriclyError{fmt.Sprintf("No metric %s on element %s", metricID, elementID)})
+		return
+	}
+
+	query := r.URL.Query()
+
+	duration, resolution, err := parseSampleRange(query.Get("duration"), query.Get("resolution"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, metriclyError{err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"samples": rollupSamples(values, duration, resolution, query.Get("rollup")),
+	})
+}
+
+// parseSampleRange parses a samples request's duration, and its resolution,
+// which defaults to one minute.
+func parseSampleRange(duration string, resolution string) (time.Duration, time.Duration, error) {
+	d, err := parseISODuration(duration)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if resolution == "" {
+		return d, time.Minute, nil
+	}
+
+	r, err := parseISODuration(resolution)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return d, r, nil
+}
+
+// rollupSamples turns the fixture values within duration into samples, oldest
+// first. Each value covers a minute; a coarser resolution rolls them up.
+func rollupSamples(values []float64, duration time.Duration, resolution time.Duration, kind string) []metriclySample {
+	// only the values within duration, i.e. the last one per minute
+	if n := int(duration / time.Minute); n < len(values) {
+		if n < 1 {
+			n = 1
+		}
+
+		values = values[len(values)-n:]
+	}
+
+	now := time.Now().Truncate(time.Minute)
+	perSample := int(resolution / time.Minute)
+	if perSample < 1 {
+		perSample = 1
+	}
+
+	samples := []metriclySample{}
+
+	// group values into samples from the newest backwards, so that the newest
+	// sample is always complete
+	for end := len(values); end > 0; end -= perSample {
+		start := end - perSample
+		if start < 0 {
+			start = 0
+		}
+
+		var s metriclySample
+		s.Timestamp = now.Add(-time.Duration(len(values)-end)*time.Minute).UnixNano() / int64(time.Millisecond)
+		s.Data.Val = rollup(kind, values[start:end])
+
+		samples = append([]metriclySample{s}, samples...)
+	}
+
+	return samples
+}
+
+func rollup(kind string, values []float64) float64 {
+	result := values[len(values)-1]
+
+	switch strings.ToUpper(kind) {
+	case string(metricly.RollupAvg):
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+
+		result = sum / float64(len(values))
+	case string(metricly.RollupMax):
+		for _, v := range values {
+			if v > result {
+				result = v
+			}
+		}
+	case string(metricly.RollupMin):
+		for _, v := range values {
+			if v < result {
+				result = v
+			}
+		}
+	}
+
+	return result
+}
+
+var isoDuration = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+
+// parseISODuration parses the subset of ISO 8601 durations that Metricly
+// clients send, e.g. "PT1H30M".
+func parseISODuration(value string) (time.Duration, error) {
+	match := isoDuration.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+
+	var d time.Duration
+
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		if match[i+1] == "" {
+			continue
+		}
+
+		n, _ := strconv.Atoi(match[i+1])
+		d += time.Duration(n) * unit
+	}
+
+	return d, nil
+}
+
+func includesField(query metricly.MetricQuery, field string) bool {
+	for _, include := range query.SourceFilter.Includes {
+		if include == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsAny(s string, items []string) bool {
+	if len(items) == 0 {
+		return true
+	}
+
+	for _, item := range items {
+		if strings.Contains(s, item) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,392 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// OctopusSpace is the space served by NewOctopus.
+	OctopusSpace = "Spaces-1"
+	// OctopusAPIKey is the API key accepted by NewOctopus.
+	OctopusAPIKey = "API-FAKE"
+)
+
+const defaultPageSize = 30
+
+// Octopus serves the Octopus API endpoints used by the CDC checks from these
+// files in Dir:
+//
+//	machines.json        machines/all and machines/{id}
+//	tenantvariables.json tenantvariables/all
+//	tenants.json         tenants/{id}
+//	projects.json        projects/all and projects/{id, name or slug}
+//	events.json          events, filtered by "regarding" and paged
+//	releases.json        projects/{id}/releases, newest first and paged
+//	deployments.json     deployments, filtered and paged
+//	dashboard.json       dashboard/dynamic, filtered by "projects"
+//
+// It also accepts health check tasks (POST tasks), which complete
+// successfully without changing any machine, and machine updates (PUT
+// machines/{id}), of which only IsDisabled is kept, in memory.
+//
+// Requests without the right X-Octopus-ApiKey are rejected, as are requests
+// for any other space.
+type Octopus struct {
+	Dir    string
+	Space  string
+	APIKey string
+
+	mu       sync.Mutex
+	tasks    []octopusRecord
+	disabled map[string]bool
+}
+
+// NewOctopus starts a fake Octopus server for OctopusSpace and OctopusAPIKey,
+// serving the fixtures in dir/octopus. Callers should Close it when done.
+func NewOctopus(dir string) *httptest.Server {
+	return httptest.NewServer(&Octopus{
+		Dir:    filepath.Join(dir, "octopus"),
+		Space:  OctopusSpace,
+		APIKey: OctopusAPIKey,
+	})
+}
+
+type octopusError struct {
+	ErrorMessage string
+}
+
+type octopusRecord map[string]interface{}
+
+func (r octopusRecord) field(name string) string {
+	s, _ := r[name].(string)
+	return s
+}
+
+func (o *Octopus) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-Octopus-ApiKey") != o.APIKey {
+		writeJSON(w, http.StatusUnauthorized, octopusError{"You must be logged in to perform this action."})
+		return
+	}
+
+	prefix := fmt.Sprintf("/api/%s/", o.Space)
+
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		o.notFound(w, r)
+		return
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, prefix), "/", 2)
+
+	switch {
+	case parts[0] == "events" && len(parts) == 1:
+		o.serveEvents(w, r)
+	case parts[0] == "deployments" && len(parts) == 1:
+		o.serveDeployments(w, r)
+	case parts[0] == "tasks" && len(parts) == 1 && r.Method == "POST":
+		o.createTask(w, r)
+	case len(parts) != 2:
+		o.notFound(w, r)
+	case parts[0] == "tasks":
+		o.serveTask(w, r, parts[1])
+	case parts[0] == "dashboard" && parts[1] == "dynamic":
+		o.serveDashboard(w, r)
+	case parts[0] == "projects" && strings.HasSuffix(parts[1], "/releases"):
+		o.serveReleases(w, r, strings.TrimSuffix(parts[1], "/releases"))
+	case parts[0] == "machines" && r.Method == "PUT":
+		o.updateMachine(w, r, parts[1])
+	case parts[0] == "machines":
+		o.serveRecords(w, r, "machines.json", parts[1], "Id")
+	case parts[0] == "tenantvariables" && parts[1] == "all":
+		o.serveRecords(w, r, "tenantvariables.json", parts[1])
+	case parts[0] == "tenants":
+		o.serveRecords(w, r, "tenants.json", parts[1], "Id")
+	case parts[0] == "projects":
+		o.serveRecords(w, r, "projects.json", parts[1], "Id", "Name", "Slug")
+	default:
+		o.notFound(w, r)
+	}
+}
+
+// serveRecords serves every record in file if id is "all", otherwise the
+// first record with id in one of the given fields.
+func (o *Octopus) serveRecords(w http.ResponseWriter, r *http.Request, file string, id string, fields ...string) {
+	var records []octopusRecord
+
+	if err := readFixture(filepath.Join(o.Dir, file), &records); err != nil {
+		writeJSON(w, http.StatusInternalServerError, octopusError{err.Error()})
+		return
+	}
+
+	if file == "machines.json" {
+		o.applyDisabled(records)
+	}
+
+	if id == "all" {
+		writeJSON(w, http.StatusOK, records)
+		return
+	}
+
+	for _, record := range records {
+		for _, field := range fields {
+			if strings.EqualFold(record.field(field), id) {
+				writeJSON(w, http.StatusOK, record)
+				return
+			}
+		}
+	}
+
+	o.notFound(w, r)
+}
+
+// serveEvents serves the events related to the "regarding" document, newest
+// first, a page at a time with a "Page.Next" link like the real API.
+func (o *Octopus) serveEvents(w http.ResponseWriter, r *http.Request) {
+	var events []octopusRecord
+
+	if err := readFixture(filepath.Join(o.Dir, "events.json"), &events); err != nil {
+		writeJSON(w, http.StatusInternalServerError, octopusError{err.Error()})
+		return
+	}
+
+	regarding := r.URL.Query().Get("regarding")
+	matched := []octopusRecord{}
+
+	for _, event := range events {
+		related, _ := event["RelatedDocumentIds"].([]interface{})
+
+		for _, id := range related {
+			if regarding == "" || id == regarding {
+				matched = append(matched, event)
+				break
+			}
+		}
+	}
+
+	o.servePage(w, r, "Event", matched)
+}
+
+// serveReleases serves a project's releases, newest first, a page at a time.
+func (o *Octopus) serveReleases(w http.ResponseWriter, r *http.Request, projectID string) {
+	var releases []octopusRecord
+
+	if err := readFixture(filepath.Join(o.Dir, "releases.json"), &releases); err != nil {
+		writeJSON(w, http.StatusInternalServerError, octopusError{err.Error()})
+		return
+	}
+
+	matched := []octopusRecord{}
+
+	for _, release := range releases {
+		if release.field("ProjectId") == projectID {
+			matched = append(matched, release)
+		}
+	}
+
+	sortNewestFirst(matched, "Assembled")
+	o.servePage(w, r, "Release", matched)
+}
+
+// serveDeployments serves the deployments matching the "projects", "tenants"
+// and "environments" filters, newest first, a page at a time.
+func (o *Octopus) serveDeployments(w http.ResponseWriter, r *http.Request) {
+	var deployments []octopusRecord
+
+	if err := readFixture(filepath.Join(o.Dir, "deployments.json"), &deployments); err != nil {
+		writeJSON(w, http.StatusInternalServerError, octopusError{err.Error()})
+		return
+	}
+
+	matched := filterRecords(deployments, r.URL.Query(), map[string]string{
+		"projects":     "ProjectId",
+		"tenants":      "TenantId",
+		"environments": "EnvironmentId",
+	})
+
+	sortNewestFirst(matched, "Created")
+	o.servePage(w, r, "Deployment", matched)
+}
+
+// serveDashboard serves the dashboard items of the "projects" filter.
+func (o *Octopus) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	var items []octopusRecord
+
+	if err := readFixture(filepath.Join(o.Dir, "dashboard.json"), &items); err != nil {
+		writeJSON(w, http.StatusInternalServerError, octopusError{err.Error()})
+		return
+	}
+
+	matched := filterRecords(items, r.URL.Query(), map[string]string{"projects": "ProjectId"})
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"Items": matched})
+}
+
+// filterRecords returns the records whose fields are in the comma-separated
+// query parameters mapped to them. Missing parameters match everything.
+func filterRecords(records []octopusRecord, query url.Values, params map[string]string) []octopusRecord {
+	matched := []octopusRecord{}
+
+	for _, record := range records {
+		ok := true
+
+		for param, field := range params {
+			if query.Get(param) == "" {
+				continue
+			}
+
+			found := false
+			for _, id := range strings.Split(query.Get(param), ",") {
+				found = found || record.field(field) == id
+			}
+
+			ok = ok && found
+		}
+
+		if ok {
+			matched = append(matched, record)
+		}
+	}
+
+	return matched
+}
+
+func sortNewestFirst(records []octopusRecord, field string) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].field(field) > records[j].field(field)
+	})
+}
+
+// servePage serves one page of items, selected by "skip" and "take", with a
+// "Page.Next" link like the real API.
+func (o *Octopus) servePage(w http.ResponseWriter, r *http.Request, itemType string, items []octopusRecord) {
+	query := r.URL.Query()
+
+	skip, _ := strconv.Atoi(query.Get("skip"))
+	take, err := strconv.Atoi(query.Get("take"))
+
+	if err != nil || take <= 0 {
+		take = defaultPageSize
+	}
+
+	if skip > len(items) {
+		skip = len(items)
+	}
+
+	end := skip + take
+	if end > len(items) {
+		end = len(items)
+	}
+
+	links := map[string]string{"Self": r.URL.RequestURI()}
+
+	if end < len(items) {
+		next := url.Values{}
+
+		for key, values := range query {
+			next[key] = values
+		}
+
+		next.Set("skip", strconv.Itoa(end))
+		next.Set("take", strconv.Itoa(take))
+		links["Page.Next"] = fmt.Sprintf("%s?%s", r.URL.Path, next.Encode())
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"ItemType":     itemType,
+		"TotalResults": len(items),
+		"ItemsPerPage": take,
+		"Items":        items[skip:end],
+		"Links":        links,
+	})
+}
+
+func (o *Octopus) notFound(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusNotFound, octopusError{fmt.Sprintf("The resource '%s' was not found.", r.URL.Path)})
+}
+
+func (o *Octopus) applyDisabled(machines []octopusRecord) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, machine := range machines {
+		if disabled, ok := o.disabled[machine.field("Id")]; ok {
+			machine["IsDisabled"] = disabled
+		}
+	}
+}
+
+func (o *Octopus) updateMachine(w http.ResponseWriter, r *http.Request, id string) {
+	var update octopusRecord
+
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeJSON(w, http.StatusBadRequest, octopusError{err.Error()})
+		return
+	}
+
+	disabled, ok := update["IsDisabled"].(bool)
+	if !ok || update.field("Id") != id {
+		writeJSON(w, http.StatusBadRequest, octopusError{"The machine resource is invalid."})
+		return
+	}
+
+	o.mu.Lock()
+	if o.disabled == nil {
+		o.disabled = make(map[string]bool)
+	}
+	o.disabled[id] = disabled
+	o.mu.Unlock()
+
+	o.serveRecords(w, r, "machines.json", id, "Id")
+}
+
+// createTask accepts a health check, which completes immediately.
+func (o *Octopus) createTask(w http.ResponseWriter, r *http.Request) {
+	var task octopusRecord
+
+	if err := json.NewDecoder(r.Body).Decode(&task); err != nil {
+		writeJSON(w, http.StatusBadRequest, octopusError{err.Error()})
+		return
+	}
+
+	if task.field("Name") != "Health" {
+		writeJSON(w, http.StatusBadRequest, octopusError{fmt.Sprintf("Unsupported task %q", task.field("Name"))})
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now().UTC()
+	task["Id"] = fmt.Sprintf("ServerTasks-%d", len(o.tasks)+1)
+	task["State"] = "Success"
+	task["IsCompleted"] = true
+	task["FinishedSuccessfully"] = true
+	task["QueueTime"] = now
+	task["CompletedTime"] = now
+	o.tasks = append(o.tasks, task)
+
+	writeJSON(w, http.StatusCreated, task)
+}
+
+func (o *Octopus) serveTask(w http.ResponseWriter, r *http.Request, id string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, task := range o.tasks {
+		if task.field("Id") == id {
+			writeJSON(w, http.StatusOK, task)
+			return
+		}
+	}
+
+	o.notFound(w, r)
+}
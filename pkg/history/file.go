@@ -0,0 +1,172 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const dayFormat = "2006-01-02"
+
+// Store keeps Records as JSON lines, in one file per (UTC) day under Dir.
+type Store struct {
+	Dir string
+}
+
+func (s Store) path(day time.Time) string {
+	return filepath.Join(s.Dir, day.UTC().Format(dayFormat)+".jsonl")
+}
+
+// Append adds records to the file for the day each record was taken.
+func (s Store) Append(records []Record) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return fmt.Errorf("error creating history directory: %v", err)
+	}
+
+	byFile := make(map[string][]Record)
+
+	for _, r := range records {
+		byFile[s.path(r.Time)] = append(byFile[s.path(r.Time)], r)
+	}
+
+	for path, records := range byFile {
+		if err := appendFile(path, records); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func appendFile(path string, records []Record) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening history file: %v", err)
+	}
+
+	enc := json.NewEncoder(f)
+
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			f.Close()
+			return fmt.Errorf("error writing history file: %v", err)
+		}
+	}
+
+	return f.Close()
+}
+
+// Since returns every record taken at or after since, oldest first.
+func (s Store) Since(since time.Time) ([]Record, error) {
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+
+	var records []Record
+	firstDay := since.UTC().Format(dayFormat)
+
+	for _, day := range files {
+		if day < firstDay {
+			continue
+		}
+
+		dayRecords, err := readFile(filepath.Join(s.Dir, day+".jsonl"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, r := range dayRecords {
+			if !r.Time.Before(since) {
+				records = append(records, r)
+			}
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+
+	return records, nil
+}
+
+// Prune deletes the files for days entirely before before.
+func (s Store) Prune(before time.Time) error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+
+	cutoff := before.UTC().Format(dayFormat)
+
+	for _, day := range files {
+		if day >= cutoff {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(s.Dir, day+".jsonl")); err != nil {
+			return fmt.Errorf("error pruning history: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// files returns the days that have a history file, sorted.
+func (s Store) files() ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(s.Dir, "*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing history files: %v", err)
+	}
+
+	days := make([]string, 0, len(matches))
+
+	for _, m := range matches {
+		day := strings.TrimSuffix(filepath.Base(m), ".jsonl")
+
+		if _, err := time.Parse(dayFormat, day); err == nil {
+			days = append(days, day)
+		}
+	}
+
+	sort.Strings(days)
+	return days, nil
+}
+
+func readFile(path string) ([]Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening history file: %v", err)
+	}
+
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var r Record
+
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("error decoding %s line %d: %v", path, line, err)
+		}
+
+		records = append(records, r)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading history file: %v", err)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,181 @@
+// Package history keeps a record of every CDC run's per-tenant latency and
+// machine health, so that chronic problems can be told apart from one-off
+// blips.
+package history
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+	"github.com/michaelmosher/monitoring/pkg/octopus"
+)
+
+// Record is one machine's state during one run. LatencySeconds is nil when no
+// latency sample was available.
+type Record struct {
+	Time           time.Time `json:"time"`
+	Instance       string    `json:"instance"`
+	Check          cdc.Check `json:"check"`
+	Tenant         string    `json:"tenant"`
+	Machine        string    `json:"machine"`
+	Status         string    `json:"status"`
+	UAID           string    `json:"uaid,omitempty"`
+	LatencySeconds *float64  `json:"latencySeconds,omitempty"`
+}
+
+// Records flattens a report's observations into Records.
+func Records(report cdc.Report) []Record {
+	var records []Record
+
+	for _, cr := range report.Results {
+		for _, o := range cr.Observations {
+			r := Record{
+				Time:     report.GeneratedAt,
+				Instance: cr.Label,
+				Check:    cr.Check,
+				Tenant:   o.Tenant,
+				Machine:  o.Machine,
+				Status:   string(o.Status),
+				UAID:     o.UAID,
+			}
+
+			if o.Sampled {
+				seconds := o.Latency.Seconds()
+				r.LatencySeconds = &seconds
+			}
+
+			records = append(records, r)
+		}
+	}
+
+	return records
+}
+
+// Point is a latency sample at a point in time.
+type Point struct {
+	Time    time.Time
+	Latency time.Duration
+}
+
+// Outage is a period during which a machine was Unavailable in every run.
+// End is the last run in which it was seen Unavailable. An outage is over at
+// the tenant's next run, whether that saw the machine healthy or not at all
+// (e.g. because it was deleted), so only an outage that includes the latest
+// run is Ongoing.
+type Outage struct {
+	Machine string
+	Start   time.Time
+	End     time.Time
+	Ongoing bool
+}
+
+// TenantHistory summarises a tenant's records.
+type TenantHistory struct {
+	Tenant  string
+	UAIDs   []string
+	Runs    int
+	Latency []Point
+	Outages []Outage
+}
+
+// Summarize builds the history of the named tenant (matched
+// case-insensitively) from records.
+func Summarize(records []Record, tenant string) TenantHistory {
+	h := TenantHistory{Tenant: tenant}
+
+	uaids := make(map[string]struct{})
+	runs := make(map[time.Time]struct{})
+	latencies := make(map[time.Time]time.Duration)
+	statuses := make(map[string]map[time.Time]octopus.HealthStatus)
+
+	for _, r := range records {
+		if !strings.EqualFold(r.Tenant, tenant) {
+			continue
+		}
+
+		h.Tenant = r.Tenant
+		runs[r.Time] = struct{}{}
+
+		if r.UAID != "" {
+			uaids[r.UAID] = struct{}{}
+		}
+
+		if r.LatencySeconds != nil {
+			latencies[r.Time] = time.Duration(*r.LatencySeconds * float64(time.Second))
+		}
+
+		if _, ok := statuses[r.Machine]; !ok {
+			statuses[r.Machine] = make(map[time.Time]octopus.HealthStatus)
+		}
+
+		// a machine may be seen by more than one check in the same run
+		if statuses[r.Machine][r.Time] != octopus.HealthUnavailable {
+			statuses[r.Machine][r.Time] = octopus.HealthStatus(r.Status)
+		}
+	}
+
+	h.Runs = len(runs)
+
+	times := make([]time.Time, 0, len(runs))
+
+	for t := range runs {
+		times = append(times, t)
+	}
+
+	sort.Slice(times, func(i, j int) bool {
+		return times[i].Before(times[j])
+	})
+
+	for uaid := range uaids {
+		h.UAIDs = append(h.UAIDs, uaid)
+	}
+
+	sort.Strings(h.UAIDs)
+
+	for t, latency := range latencies {
+		h.Latency = append(h.Latency, Point{Time: t, Latency: latency})
+	}
+
+	sort.Slice(h.Latency, func(i, j int) bool {
+		return h.Latency[i].Time.Before(h.Latency[j].Time)
+	})
+
+	for machine, byTime := range statuses {
+		h.Outages = append(h.Outages, outages(machine, times, byTime)...)
+	}
+
+	sort.Slice(h.Outages, func(i, j int) bool {
+		return h.Outages[i].Start.Before(h.Outages[j].Start)
+	})
+
+	return h
+}
+
+// outages finds machine's outages, given the times of every run in order and
+// its status in the runs that observed it.
+func outages(machine string, times []time.Time, byTime map[time.Time]octopus.HealthStatus) []Outage {
+	var found []Outage
+	var current *Outage
+
+	for _, t := range times {
+		if byTime[t] != octopus.HealthUnavailable {
+			current = nil
+			continue
+		}
+
+		if current == nil {
+			found = append(found, Outage{Machine: machine, Start: t})
+			current = &found[len(found)-1]
+		}
+
+		current.End = t
+	}
+
+	if current != nil {
+		current.Ongoing = true
+	}
+
+	return found
+}
@@ -0,0 +1,93 @@
+package history
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+	"github.com/michaelmosher/monitoring/pkg/octopus"
+)
+
+func TestSummarizeOutages(t *testing.T) {
+	start := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+	run := func(i int) time.Time { return start.Add(time.Duration(i) * time.Hour) }
+
+	// observed is the status of each machine seen in one run
+	type observed map[string]octopus.HealthStatus
+
+	tests := []struct {
+		name string
+		runs []observed
+		want []Outage
+	}{
+		{
+			name: "recovered",
+			runs: []observed{
+				{"nuc-01": octopus.HealthUnavailable},
+				{"nuc-01": octopus.HealthUnavailable},
+				{"nuc-01": octopus.HealthHealthy},
+			},
+			want: []Outage{{Machine: "nuc-01", Start: run(0), End: run(1)}},
+		},
+		{
+			name: "ongoing",
+			runs: []observed{
+				{"nuc-01": octopus.HealthHealthy},
+				{"nuc-01": octopus.HealthUnavailable},
+				{"nuc-01": octopus.HealthUnavailable},
+			},
+			want: []Outage{{Machine: "nuc-01", Start: run(1), End: run(2), Ongoing: true}},
+		},
+		{
+			name: "machine no longer observed",
+			runs: []observed{
+				{"nuc-01": octopus.HealthUnavailable, "nuc-02": octopus.HealthHealthy},
+				{"nuc-02": octopus.HealthHealthy},
+				{"nuc-02": octopus.HealthHealthy},
+			},
+			want: []Outage{{Machine: "nuc-01", Start: run(0), End: run(0)}},
+		},
+		{
+			name: "gap between outages",
+			runs: []observed{
+				{"nuc-01": octopus.HealthUnavailable},
+				{"nuc-02": octopus.HealthHealthy},
+				{"nuc-01": octopus.HealthUnavailable},
+			},
+			want: []Outage{
+				{Machine: "nuc-01", Start: run(0), End: run(0)},
+				{Machine: "nuc-01", Start: run(2), End: run(2), Ongoing: true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var records []Record
+
+			for i, machines := range tt.runs {
+				for machine, status := range machines {
+					records = append(records, Record{
+						Time:     run(i),
+						Instance: "ASI",
+						Check:    cdc.CheckOfflineNUCs,
+						Tenant:   "Acme Health",
+						Machine:  machine,
+						Status:   string(status),
+					})
+				}
+			}
+
+			got := Summarize(records, "acme health")
+
+			if got.Runs != len(tt.runs) {
+				t.Errorf("got %d runs, want %d", got.Runs, len(tt.runs))
+			}
+
+			if !reflect.DeepEqual(got.Outages, tt.want) {
+				t.Errorf("got outages %+v, want %+v", got.Outages, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/metricly"
+)
+
+// batchSamplesField is the metricQuery source field holding each metric's
+// samples between the query's startDate and endDate.
+const batchSamplesField = "samples"
+
+type batchResponseData struct {
+	Page struct {
+		Content []struct {
+			ID        string
+			ElementID string
+			// Samples is nil if Metricly didn't return the field at all.
+			Samples *[]sampleData
+		}
+	}
+	NumberOfElements int
+	Last             bool
+}
+
+// FetchBatchSamples returns the raw samples of many metrics, oldest first,
+// from a metricQuery over the last query.Duration that includes each metric's
+// samples. It returns metricly.ErrBatchUnsupported if query asks for a rollup
+// or resolution, which a metricQuery can't apply, or if Metricly doesn't have
+// the metricQuery endpoint or doesn't return the samples field.
+func (s Service) FetchBatchSamples(ctx context.Context, metrics []metricly.Metric, query metricly.SampleQuery) (map[metricly.Metric][]metricly.Sample, error) {
+	if query.Resolution > 0 || (query.Rollup != "" && query.Rollup != metricly.RollupZero) {
+		return nil, metricly.ErrBatchUnsupported
+	}
+
+	byID := make(map[metricly.Metric]metricly.Metric, len(metrics))
+	for _, metric := range metrics {
+		byID[metricly.Metric{ID: metric.ID, ElementID: metric.ElementID}] = metric
+	}
+
+	mq := newBatchQuery(metrics, query.Duration)
+	samples := make(map[metricly.Metric][]metricly.Sample, len(metrics))
+	matched, withSamples := 0, 0
+
+	for {
+		page, err := s.fetchBatchPage(ctx, mq)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, result := range page.Page.Content {
+			metric, ok := byID[metricly.Metric{ID: result.ID, ElementID: result.ElementID}]
+			if !ok {
+				continue
+			}
+
+			matched++
+
+			if result.Samples == nil {
+				continue
+			}
+
+			withSamples++
+
+			if len(*result.Samples) > 0 {
+				samples[metric] = toSamples(*result.Samples)
+			}
+		}
+
+		if page.Last || page.NumberOfElements == 0 || len(page.Page.Content) == 0 {
+			break
+		}
+
+		mq.Page++
+	}
+
+	if matched > 0 && withSamples == 0 {
+		return nil, metricly.ErrBatchUnsupported
+	}
+
+	return samples, nil
+}
+
+// newBatchQuery returns a metricQuery for metrics, and their samples over the
+// last duration.
+func newBatchQuery(metrics []metricly.Metric, duration time.Duration) metricly.MetricQuery {
+	now := time.Now()
+	mq := new(metricly.MetricQuery).
+		SetStartDate(now.Add(-duration)).
+		SetEndDate(now).
+		SetSourceIncludes("id", "elementId", "fqn", batchSamplesField)
+
+	elements := make(map[string]bool)
+
+	for _, metric := range metrics {
+		if !elements[metric.ElementID] {
+			elements[metric.ElementID] = true
+			mq.AddElement(metric.ElementID)
+		}
+
+		mq.AddMetric(metric.FQN)
+	}
+
+	mq.PageSize = len(metrics)
+
+	return *mq
+}
+
+func (s Service) fetchBatchPage(ctx context.Context, query metricly.MetricQuery) (batchResponseData, error) {
+	req, err := s.createMetricsRequest(ctx, query)
+
+	if err != nil {
+		return batchResponseData{}, fmt.Errorf("error creating API request: %v", err)
+	}
+
+	resp, err := s.HTTPClient.Do(req)
+
+	if err != nil {
+		return batchResponseData{}, fmt.Errorf("error executing API request: %v", err)
+	}
+
+	return handleBatchResponse(resp)
+}
+
+func handleBatchResponse(resp *http.Response) (batchResponseData, error) {
+	defer resp.Body.Close()
+
+	var d batchResponseData
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+		return d, metricly.ErrBatchUnsupported
+	default:
+		return d, fmt.Errorf("unexpected response status: %s", resp.Status)
+	}
+
+	err := json.NewDecoder(resp.Body).Decode(&d)
+
+	if err != nil {
+		return d, fmt.Errorf("error decoding JSON: %v", err)
+	}
+
+	return d, nil
+}
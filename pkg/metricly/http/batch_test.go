@@ -0,0 +1,41 @@
+package http
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/michaelmosher/monitoring/pkg/metricly"
+)
+
+func TestHandleBatchResponse(t *testing.T) {
+	tests := []struct {
+		status      int
+		unsupported bool
+	}{
+		{http.StatusBadRequest, false},
+		{http.StatusUnauthorized, false},
+		{http.StatusForbidden, false},
+		{http.StatusNotFound, true},
+		{http.StatusMethodNotAllowed, true},
+		{http.StatusTooManyRequests, false},
+		{http.StatusInternalServerError, false},
+		{http.StatusNotImplemented, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			resp := jsonResponse(`{}`)
+			resp.StatusCode = tt.status
+
+			_, err := handleBatchResponse(resp)
+
+			if err == nil {
+				t.Fatalf("got no error")
+			}
+
+			if unsupported := err == metricly.ErrBatchUnsupported; unsupported != tt.unsupported {
+				t.Errorf("got error %v, want ErrBatchUnsupported: %t", err, tt.unsupported)
+			}
+		})
+	}
+}
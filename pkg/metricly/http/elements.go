@@ -1,43 +1,118 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
+	"time"
 
 	"github.com/michaelmosher/monitoring/pkg/metricly"
 )
 
 type sampleResponseData struct {
-	Samples []struct {
-		Data struct {
-			Val float64
-		}
+	Samples []sampleData
+}
+
+type sampleData struct {
+	Timestamp sampleTimestamp
+	Data      struct {
+		Val float64
+	}
+}
+
+// sampleTimestamp accepts either epoch milliseconds or an RFC 3339 string.
+type sampleTimestamp time.Time
+
+func (t *sampleTimestamp) UnmarshalJSON(data []byte) error {
+	var millis int64
+	if err := json.Unmarshal(data, &millis); err == nil {
+		*t = sampleTimestamp(time.Unix(0, millis*int64(time.Millisecond)).UTC())
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("unexpected timestamp %s", data)
+	}
+
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return err
 	}
+
+	*t = sampleTimestamp(parsed)
+	return nil
 }
 
 // FetchMetricValue returns the latest value of a given time-series data metric.
-func (s Service) FetchMetricValue(metric metricly.Metric) (float64, error) {
-	req, err := s.createSampleRequest(metric)
+func (s Service) FetchMetricValue(ctx context.Context, metric metricly.Metric) (float64, error) {
+	d, err := s.fetchSamples(ctx, metric, metricly.LatestSampleQuery)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(d.Samples) == 0 {
+		return 0, fmt.Errorf("0 results from getMetricResults API")
+	}
+
+	return d.Samples[0].Data.Val, nil
+}
+
+// FetchMetricSamples returns a metric's samples over the range described by
+// query, oldest first.
+func (s Service) FetchMetricSamples(ctx context.Context, metric metricly.Metric, query metricly.SampleQuery) ([]metricly.Sample, error) {
+	d, err := s.fetchSamples(ctx, metric, query)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return toSamples(d.Samples), nil
+}
+
+// toSamples converts samples from a Metricly response, oldest first.
+func toSamples(data []sampleData) []metricly.Sample {
+	samples := make([]metricly.Sample, 0, len(data))
+
+	for _, sample := range data {
+		samples = append(samples, metricly.Sample{
+			Time:  time.Time(sample.Timestamp),
+			Value: sample.Data.Val,
+		})
+	}
+
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Time.Before(samples[j].Time)
+	})
+
+	return samples
+}
+
+func (s Service) fetchSamples(ctx context.Context, metric metricly.Metric, query metricly.SampleQuery) (sampleResponseData, error) {
+	req, err := s.createSampleRequest(ctx, metric, query)
 
 	if err != nil {
-		return 0, fmt.Errorf("error creating API request: %v", err)
+		return sampleResponseData{}, fmt.Errorf("error creating API request: %v", err)
 	}
 
 	resp, err := s.HTTPClient.Do(req)
 
 	if err != nil {
-		return 0, fmt.Errorf("error executing API request: %v", err)
+		return sampleResponseData{}, fmt.Errorf("error executing API request: %v", err)
 	}
 
 	return handleSampleResponse(resp)
 }
 
-func (s Service) createSampleRequest(metric metricly.Metric) (*http.Request, error) {
-	req, err := http.NewRequest(
+func (s Service) createSampleRequest(ctx context.Context, metric metricly.Metric, query metricly.SampleQuery) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(
+		ctx,
 		"GET",
 		fmt.Sprintf("%s/elements/%s/metrics/%s/samples",
-			apiBaseURL,
+			s.apiBaseURL(),
 			metric.ElementID,
 			metric.ID),
 		nil,
@@ -48,30 +123,64 @@ func (s Service) createSampleRequest(metric metricly.Metric) (*http.Request, err
 	}
 
 	req.Header.Add("Content-type", "application/json")
-	req.SetBasicAuth(s.Username, s.Password)
+	s.authenticate(req)
+
+	rollup := query.Rollup
+	if rollup == "" {
+		rollup = metricly.RollupZero
+	}
 
 	q := req.URL.Query()
-	q.Add("duration", "PT1M")
-	q.Add("rollup", "ZERO")
+	q.Add("duration", isoDuration(query.Duration))
+	q.Add("rollup", string(rollup))
+
+	if query.Resolution > 0 {
+		q.Add("resolution", isoDuration(query.Resolution))
+	}
 
 	req.URL.RawQuery = q.Encode()
 
 	return req, nil
 }
 
-func handleSampleResponse(resp *http.Response) (float64, error) {
+// isoDuration formats d as an ISO 8601 duration, e.g. "PT1H30M".
+func isoDuration(d time.Duration) string {
+	if d < time.Second {
+		return "PT0S"
+	}
+
+	h := d / time.Hour
+	d -= h * time.Hour
+	m := d / time.Minute
+	d -= m * time.Minute
+	sec := d / time.Second
+
+	out := "PT"
+
+	if h > 0 {
+		out += fmt.Sprintf("%dH", h)
+	}
+
+	if m > 0 {
+		out += fmt.Sprintf("%dM", m)
+	}
+
+	if sec > 0 {
+		out += fmt.Sprintf("%dS", sec)
+	}
+
+	return out
+}
+
+func handleSampleResponse(resp *http.Response) (sampleResponseData, error) {
 	defer resp.Body.Close()
 
 	var d sampleResponseData
 	err := json.NewDecoder(resp.Body).Decode(&d)
 
 	if err != nil {
-		return 0, fmt.Errorf("error decoding JSON: %v", err)
+		return d, fmt.Errorf("error decoding JSON: %v", err)
 	}
 
-	if len(d.Samples) == 0 {
-		return 0, fmt.Errorf("0 results from getMetricResults API")
-	}
-
-	return d.Samples[0].Data.Val, nil
+	return d, nil
 }
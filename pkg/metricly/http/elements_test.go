@@ -0,0 +1,107 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func jsonResponse(body string) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader([]byte(body))),
+	}
+}
+
+func TestSampleTimestampUnmarshalJSON(t *testing.T) {
+	noon := time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		data    string
+		want    time.Time
+		wantErr string
+	}{
+		{"epoch milliseconds", `1588334400000`, noon, ""},
+		{"RFC 3339", `"2020-05-01T12:00:00Z"`, noon, ""},
+		{"fractional milliseconds", `1588334400000.5`, time.Time{}, "unexpected timestamp"},
+		{"not a date", `"yesterday"`, time.Time{}, "cannot parse"},
+		{"boolean", `true`, time.Time{}, "unexpected timestamp true"},
+		{"object", `{"ms": 1588334400000}`, time.Time{}, "unexpected timestamp"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got sampleTimestamp
+			err := json.Unmarshal([]byte(tt.data), &got)
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			if !time.Time(got).Equal(tt.want) {
+				t.Errorf("got %s, want %s", time.Time(got), tt.want)
+			}
+		})
+	}
+}
+
+var sampleResponseTests = []struct {
+	name        string
+	body        string
+	wantSamples int
+	wantErr     string
+}{
+	{"valid", `{"samples": [{"timestamp": 1588334400000, "data": {"val": 42}}]}`, 1, ""},
+	{"no samples", `{"samples": null}`, 0, ""},
+	{"missing value", `{"samples": [{"timestamp": 1588334400000}]}`, 1, ""},
+	{"bad timestamp", `{"samples": [{"timestamp": "noon", "data": {"val": 42}}]}`, 0, "error decoding JSON"},
+	{"value not a number", `{"samples": [{"timestamp": 1588334400000, "data": {"val": "42"}}]}`, 0, "error decoding JSON"},
+	{"samples not a list", `{"samples": {"timestamp": 1588334400000}}`, 0, "error decoding JSON"},
+	{"truncated", `{"samples": [{"timestamp": 1588334400000`, 0, "error decoding JSON"},
+}
+
+func TestHandleSampleResponse(t *testing.T) {
+	for _, tt := range sampleResponseTests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := handleSampleResponse(jsonResponse(tt.body))
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			if len(d.Samples) != tt.wantSamples {
+				t.Errorf("got %d samples, want %d", len(d.Samples), tt.wantSamples)
+			}
+		})
+	}
+}
+
+func FuzzHandleSampleResponse(f *testing.F) {
+	for _, tt := range sampleResponseTests {
+		f.Add(tt.body)
+	}
+
+	f.Fuzz(func(t *testing.T, body string) {
+		handleSampleResponse(jsonResponse(body))
+		handleBatchResponse(jsonResponse(body))
+	})
+}
@@ -1,17 +1,86 @@
 package http
 
 import (
+	"fmt"
 	"net/http"
+	"sort"
+	"strings"
 )
 
-const apiBaseURL = "https://us.cloudwisdom.virtana.com"
+// DefaultBaseURL is the Metricly API used when Service.BaseURL is empty.
+const DefaultBaseURL = "https://us.cloudwisdom.virtana.com"
+
+// regions are the Metricly (Virtana CloudWisdom) API endpoints by region.
+var regions = map[string]string{
+	"us": DefaultBaseURL,
+	"eu": "https://eu.cloudwisdom.virtana.com",
+}
 
 type httpClient interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
+// Credentials authenticate requests to Metricly, with either an API key or a
+// username and password. The API key wins if both are set.
+type Credentials struct {
+	Username string
+	Password string
+	APIKey   string
+}
+
 type Service struct {
 	HTTPClient httpClient
 	Username   string
 	Password   string
+	// APIKey is sent as a bearer token instead of basic auth, if it is set.
+	APIKey string
+	// BaseURL is the Metricly API to call, e.g. a proxy or a stub server in
+	// tests. Defaults to DefaultBaseURL.
+	BaseURL string
+}
+
+// New creates a Metricly client for the API at baseURL, which may be empty to
+// use DefaultBaseURL.
+func New(client httpClient, baseURL string, credentials Credentials) Service {
+	return Service{
+		HTTPClient: client,
+		Username:   credentials.Username,
+		Password:   credentials.Password,
+		APIKey:     credentials.APIKey,
+		BaseURL:    baseURL,
+	}
+}
+
+// RegionURL returns the base URL of the Metricly API for a region such as
+// "us" or "eu".
+func RegionURL(region string) (string, error) {
+	if url, ok := regions[strings.ToLower(region)]; ok {
+		return url, nil
+	}
+
+	known := make([]string, 0, len(regions))
+	for r := range regions {
+		known = append(known, r)
+	}
+
+	sort.Strings(known)
+
+	return "", fmt.Errorf("unknown Metricly region %q (expected one of %s)", region, strings.Join(known, ", "))
+}
+
+func (s Service) apiBaseURL() string {
+	if s.BaseURL == "" {
+		return DefaultBaseURL
+	}
+
+	return strings.TrimSuffix(s.BaseURL, "/")
+}
+
+func (s Service) authenticate(req *http.Request) {
+	if s.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+s.APIKey)
+		return
+	}
+
+	req.SetBasicAuth(s.Username, s.Password)
 }
@@ -2,11 +2,13 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 
 	"github.com/michaelmosher/monitoring/pkg/metricly"
+	"github.com/michaelmosher/monitoring/pkg/retry"
 )
 
 type metricsResponseData struct {
@@ -17,31 +19,60 @@ type metricsResponseData struct {
 	Last             bool
 }
 
-func (s Service) FetchMetrics(query metricly.MetricQuery) ([]metricly.Metric, error) {
-	req, err := s.createMetricsRequest(query)
+// FetchMetrics returns every metric matching query, requesting successive
+// pages (starting from query.Page) until Metricly reports the last one. A
+// positive limit stops paging once that many metrics have been collected.
+func (s Service) FetchMetrics(ctx context.Context, query metricly.MetricQuery, limit int) ([]metricly.Metric, error) {
+	metrics := []metricly.Metric{}
+
+	for {
+		page, err := s.fetchMetricsPage(ctx, query)
+
+		if err != nil {
+			return nil, err
+		}
+
+		metrics = append(metrics, page.Page.Content...)
+
+		if limit > 0 && len(metrics) >= limit {
+			return metrics[:limit], nil
+		}
+
+		if page.Last || page.NumberOfElements == 0 || len(page.Page.Content) == 0 {
+			return metrics, nil
+		}
+
+		query.Page++
+	}
+}
+
+func (s Service) fetchMetricsPage(ctx context.Context, query metricly.MetricQuery) (metricsResponseData, error) {
+	req, err := s.createMetricsRequest(ctx, query)
 
 	if err != nil {
-		return nil, fmt.Errorf("error creating API request: %v", err)
+		return metricsResponseData{}, fmt.Errorf("error creating API request: %v", err)
 	}
 
 	resp, err := s.HTTPClient.Do(req)
 
 	if err != nil {
-		return nil, fmt.Errorf("error executing API request: %v", err)
+		return metricsResponseData{}, fmt.Errorf("error executing API request: %v", err)
 	}
 
 	return handleMetricsResponse(resp)
 }
 
-func (s Service) createMetricsRequest(query metricly.MetricQuery) (*http.Request, error) {
+func (s Service) createMetricsRequest(ctx context.Context, query metricly.MetricQuery) (*http.Request, error) {
 	queryBytes, err := json.Marshal(query)
 	if err != nil {
 		return nil, fmt.Errorf("error marshalling JSON: %v", err)
 	}
 
-	req, err := http.NewRequest(
+	// a metricQuery only reads, so it's safe to retry despite being a POST
+	req, err := http.NewRequestWithContext(
+		retry.Idempotent(ctx),
 		"POST",
-		fmt.Sprintf("%s/metrics/elasticsearch/metricQuery", apiBaseURL),
+		fmt.Sprintf("%s/metrics/elasticsearch/metricQuery", s.apiBaseURL()),
 		bytes.NewReader(queryBytes),
 	)
 
@@ -50,20 +81,20 @@ func (s Service) createMetricsRequest(query metricly.MetricQuery) (*http.Request
 	}
 
 	req.Header.Add("Content-type", "application/json")
-	req.SetBasicAuth(s.Username, s.Password)
+	s.authenticate(req)
 
 	return req, nil
 }
 
-func handleMetricsResponse(resp *http.Response) ([]metricly.Metric, error) {
+func handleMetricsResponse(resp *http.Response) (metricsResponseData, error) {
 	defer resp.Body.Close()
 
 	var d metricsResponseData
 	err := json.NewDecoder(resp.Body).Decode(&d)
 
 	if err != nil {
-		return nil, fmt.Errorf("error decoding JSON: %v", err)
+		return d, fmt.Errorf("error decoding JSON: %v", err)
 	}
 
-	return d.Page.Content, nil
+	return d, nil
 }
@@ -1,5 +1,10 @@
 package metricly
 
+import (
+	"context"
+	"errors"
+)
+
 // Metric is a structure that defines a "metric"; used to look up a metric "result".
 type Metric struct {
 	ID        string
@@ -8,10 +13,22 @@ type Metric struct {
 }
 
 type client interface {
-	FetchMetrics(MetricQuery) ([]Metric, error)
-	FetchMetricValue(Metric) (float64, error)
+	FetchMetrics(context.Context, MetricQuery, int) ([]Metric, error)
+	FetchMetricValue(context.Context, Metric) (float64, error)
+	FetchMetricSamples(context.Context, Metric, SampleQuery) ([]Sample, error)
+}
+
+// batchClient is implemented by clients that can fetch samples for many
+// metrics in one request.
+type batchClient interface {
+	FetchBatchSamples(context.Context, []Metric, SampleQuery) (map[Metric][]Sample, error)
 }
 
+// ErrBatchUnsupported is returned by the batch fetches when the client, or the
+// Metricly API behind it, can't fetch several metrics at once. Callers should
+// fall back to fetching one metric at a time.
+var ErrBatchUnsupported = errors.New("batch sample requests are not supported")
+
 type Service struct {
 	client client
 }
@@ -20,10 +37,50 @@ func New(client client) Service {
 	return Service{client: client}
 }
 
-func (s Service) FetchMetrics(query MetricQuery) ([]Metric, error) {
-	return s.client.FetchMetrics(query)
+// FetchMetrics returns every metric matching query, or at most limit metrics
+// if limit is positive.
+func (s Service) FetchMetrics(ctx context.Context, query MetricQuery, limit int) ([]Metric, error) {
+	return s.client.FetchMetrics(ctx, query, limit)
+}
+
+func (s Service) FetchMetricValue(ctx context.Context, metric Metric) (float64, error) {
+	return s.client.FetchMetricValue(ctx, metric)
+}
+
+// FetchMetricSamples returns a metric's samples over the range described by
+// query, oldest first.
+func (s Service) FetchMetricSamples(ctx context.Context, metric Metric, query SampleQuery) ([]Sample, error) {
+	return s.client.FetchMetricSamples(ctx, metric, query)
 }
 
-func (s Service) FetchMetricValue(metric Metric) (float64, error) {
-	return s.client.FetchMetricValue(metric)
+// FetchBatchSamples returns the samples over the range described by query for
+// each of metrics, oldest first, in as few requests as the client allows.
+// Metrics without any samples are left out of the result.
+func (s Service) FetchBatchSamples(ctx context.Context, metrics []Metric, query SampleQuery) (map[Metric][]Sample, error) {
+	batch, ok := s.client.(batchClient)
+	if !ok {
+		return nil, ErrBatchUnsupported
+	}
+
+	return batch.FetchBatchSamples(ctx, metrics, query)
+}
+
+// FetchMetricValues is the batch version of FetchMetricValue: it returns the
+// latest value of each of metrics that has one.
+func (s Service) FetchMetricValues(ctx context.Context, metrics []Metric) (map[Metric]float64, error) {
+	samples, err := s.FetchBatchSamples(ctx, metrics, LatestSampleQuery)
+
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[Metric]float64, len(samples))
+
+	for metric, series := range samples {
+		if len(series) > 0 {
+			values[metric] = series[len(series)-1].Value
+		}
+	}
+
+	return values, nil
 }
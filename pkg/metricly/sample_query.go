@@ -0,0 +1,36 @@
+package metricly
+
+import (
+	"time"
+)
+
+// Rollup is how Metricly combines raw data points into one sample.
+type Rollup string
+
+const (
+	RollupZero Rollup = "ZERO"
+	RollupAvg  Rollup = "AVG"
+	RollupMax  Rollup = "MAX"
+	RollupMin  Rollup = "MIN"
+)
+
+// Sample is a single timestamped value of a metric.
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// SampleQuery describes the range of samples to fetch for a metric: the last
+// Duration, with raw data rolled up into one sample per Resolution.
+type SampleQuery struct {
+	Duration   time.Duration
+	Rollup     Rollup
+	Resolution time.Duration
+}
+
+// LatestSampleQuery is the query used by FetchMetricValue: just the last
+// minute, without any rollup.
+var LatestSampleQuery = SampleQuery{
+	Duration: time.Minute,
+	Rollup:   RollupZero,
+}
@@ -0,0 +1,291 @@
+// Package notify posts CDC status reports to chat incoming-webhooks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+	"github.com/michaelmosher/monitoring/pkg/state"
+)
+
+// Format selects the webhook payload to render.
+type Format string
+
+const (
+	FormatSlack Format = "slack"
+	FormatTeams Format = "teams"
+)
+
+// Mode controls when a Notifier actually posts.
+type Mode string
+
+const (
+	// ModeAlways posts every report.
+	ModeAlways Mode = "always"
+	// ModeChanged posts a report only if something has changed. When the
+	// caller tracks state between runs, that means a finding is new, has
+	// recovered, or has changed severity, or a check has started failing
+	// since this Notifier's last report. Otherwise it means something is
+	// unhealthy or unknown, or the report differs from the last one this
+	// Notifier posted.
+	ModeChanged Mode = "changed"
+)
+
+type httpDoer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Notifier renders cdc.Reports and posts them to an incoming webhook.
+type Notifier struct {
+	httpClient httpDoer
+	webhookURL string
+	format     Format
+	mode       Mode
+
+	mu         sync.Mutex
+	lastDigest string
+	lastErrors map[string]bool
+}
+
+// New creates a Notifier. An empty mode means ModeAlways.
+func New(doer httpDoer, webhookURL string, format Format, mode Mode) (*Notifier, error) {
+	if webhookURL == "" {
+		return nil, fmt.Errorf("missing webhook URL")
+	}
+
+	if format != FormatSlack && format != FormatTeams {
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+
+	if mode == "" {
+		mode = ModeAlways
+	}
+
+	if mode != ModeAlways && mode != ModeChanged {
+		return nil, fmt.Errorf("unknown mode %q", mode)
+	}
+
+	return &Notifier{
+		httpClient: doer,
+		webhookURL: webhookURL,
+		format:     format,
+		mode:       mode,
+	}, nil
+}
+
+// Notify posts report, unless the Notifier's mode says it isn't worth
+// posting. changes may be nil if the caller doesn't track state between
+// runs; otherwise new and recovered findings are marked as such. It reports
+// whether anything was posted.
+func (n *Notifier) Notify(ctx context.Context, report cdc.Report, changes []state.Change) (bool, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	digest := digest(report)
+	errs := errorKeys(report)
+
+	if n.mode == ModeChanged {
+		// state doesn't track errors, so compare them with the last report
+		if changes != nil && !state.Transitioned(changes) && !newErrors(errs, n.lastErrors) {
+			n.lastErrors = errs
+			return false, nil
+		}
+
+		if changes == nil && healthy(report) && digest == n.lastDigest {
+			return false, nil
+		}
+	}
+
+	payload, err := n.render(report, changes)
+	if err != nil {
+		return false, err
+	}
+
+	if err := n.post(ctx, payload); err != nil {
+		return false, err
+	}
+
+	n.lastDigest = digest
+	n.lastErrors = errs
+	return true, nil
+}
+
+func (n *Notifier) render(report cdc.Report, changes []state.Change) (interface{}, error) {
+	switch n.format {
+	case FormatSlack:
+		return renderSlack(report, changes), nil
+	case FormatTeams:
+		return renderTeams(report, changes), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", n.format)
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", n.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("error creating request object: %v", err)
+	}
+
+	req.Header.Add("Content-type", "application/json")
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error executing webhook request: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
+
+// healthy reports whether report has no findings and no errors.
+func healthy(report cdc.Report) bool {
+	for _, cr := range report.Results {
+		if len(cr.Findings) > 0 || len(cr.Errors) > 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// digest summarises which tenants are unhealthy, ignoring durations (which
+// change on every run), so that two reports can be compared.
+func digest(report cdc.Report) string {
+	var lines []string
+
+	for _, cr := range report.Results {
+		for _, f := range cr.Findings {
+			lines = append(lines, fmt.Sprintf("%s/%s/%s/%s", cr.Label, cr.Check, f.Tenant, f.Severity))
+		}
+
+		for _, e := range cr.Errors {
+			lines = append(lines, fmt.Sprintf("%s/%s/error/%s/%s", cr.Label, cr.Check, e.Source, e.Tenant))
+		}
+	}
+
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+// errorKeys identifies each of report's errors, ignoring the message.
+func errorKeys(report cdc.Report) map[string]bool {
+	keys := make(map[string]bool)
+
+	for _, cr := range report.Results {
+		for _, e := range cr.Errors {
+			keys[fmt.Sprintf("%s/%s/%s/%s", cr.Label, cr.Check, e.Source, e.Tenant)] = true
+		}
+	}
+
+	return keys
+}
+
+// newErrors reports whether any of errs wasn't in previous.
+func newErrors(errs, previous map[string]bool) bool {
+	for key := range errs {
+		if !previous[key] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// section is the format-independent content of one check's result.
+type section struct {
+	title string
+	lines []string
+}
+
+func sections(report cdc.Report, changes []state.Change) []section {
+	statuses := make(map[string]state.Status, len(changes))
+	var unknown, recovered []string
+
+	for _, c := range changes {
+		statuses[changeKey(c.Instance, c.Check, c.Tenant)] = c.Status
+
+		if c.Status == state.StatusRecovered {
+			recovered = append(recovered, fmt.Sprintf("%s (%s %s)", c.Tenant, c.Instance, c.Check))
+		}
+	}
+
+	all := make([]section, 0, len(report.Results)+2)
+
+	for _, cr := range report.Results {
+		s := section{title: fmt.Sprintf("%s %s", cr.DisplayName, cr.Check.Description())}
+
+		for _, f := range cr.Findings {
+			line := describe(cr.Check, f)
+
+			switch status := statuses[changeKey(cr.Label, cr.Check, f.Tenant)]; status {
+			case state.StatusNew, state.StatusEscalated, state.StatusDeescalated:
+				line += " [" + strings.ToUpper(string(status)) + "]"
+			}
+
+			s.lines = append(s.lines, line)
+		}
+
+		for _, e := range cr.Errors {
+			unknown = append(unknown, fmt.Sprintf("%s: %s", s.title, e))
+		}
+
+		all = append(all, s)
+	}
+
+	if len(recovered) > 0 {
+		all = append(all, section{title: "Recovered since the last run", lines: recovered})
+	}
+
+	if len(unknown) > 0 {
+		all = append(all, section{title: "UNKNOWN (data could not be retrieved)", lines: unknown})
+	}
+
+	return all
+}
+
+func changeKey(instance string, check cdc.Check, tenant string) string {
+	return fmt.Sprintf("%s\x00%s\x00%s", instance, check, tenant)
+}
+
+func describe(check cdc.Check, f cdc.Finding) string {
+	details := string(check)
+
+	if f.Duration > 0 {
+		details += fmt.Sprintf(" for %.1f hours", f.Duration.Hours())
+	}
+
+	if f.Severity == cdc.SeverityWarning {
+		details += ", " + string(f.Severity)
+	}
+
+	if f.Reason != "" {
+		return fmt.Sprintf("%s (%s): %s", f.Tenant, details, f.Reason)
+	}
+
+	return fmt.Sprintf("%s (%s)", f.Tenant, details)
+}
+
+func title(report cdc.Report) string {
+	return fmt.Sprintf("CDC Install/Replication status (%s)", report.GeneratedAt.Format("2006-01-02 15:04 MST"))
+}
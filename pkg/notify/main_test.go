@@ -0,0 +1,288 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+	"github.com/michaelmosher/monitoring/pkg/state"
+)
+
+// webhook records the body of every request it receives, and replies with
+// status (or 200 if it's zero).
+type webhook struct {
+	status int
+
+	mu     sync.Mutex
+	bodies [][]byte
+}
+
+func (w *webhook) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	w.mu.Lock()
+	w.bodies = append(w.bodies, body)
+	w.mu.Unlock()
+
+	if w.status != 0 {
+		rw.WriteHeader(w.status)
+		rw.Write([]byte("no_text\n"))
+	}
+}
+
+func (w *webhook) posts() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return len(w.bodies)
+}
+
+// decode unmarshals the last body the webhook received into v.
+func (w *webhook) decode(t *testing.T, v interface{}) {
+	t.Helper()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.bodies) == 0 {
+		t.Fatal("got no posts, want at least 1")
+	}
+
+	if err := json.Unmarshal(w.bodies[len(w.bodies)-1], v); err != nil {
+		t.Fatalf("error decoding post: %v", err)
+	}
+}
+
+func newNotifier(t *testing.T, w *webhook, format Format, mode Mode) *Notifier {
+	t.Helper()
+
+	ts := httptest.NewServer(w)
+	t.Cleanup(ts.Close)
+
+	n, err := New(ts.Client(), ts.URL, format, mode)
+	if err != nil {
+		t.Fatalf("New returned error: %v", err)
+	}
+
+	return n
+}
+
+var generatedAt = time.Date(2020, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// unhealthyReport has an offline finding and an idle check that couldn't
+// reach Metricly.
+func unhealthyReport() cdc.Report {
+	return cdc.Report{
+		GeneratedAt: generatedAt,
+		Results: []cdc.CheckResult{
+			{Label: "ASI", DisplayName: "ASI", Check: cdc.CheckOfflineNUCs, Result: cdc.Result{
+				Findings: []cdc.Finding{{Tenant: "Acme Health", Duration: 3 * time.Hour, Severity: cdc.SeverityCritical}},
+			}},
+			{Label: "ASI", DisplayName: "ASI", Check: cdc.CheckIdleMachines, Result: cdc.Result{
+				Errors: []cdc.Error{{Source: "metricly", Err: errors.New("connection refused")}},
+			}},
+		},
+	}
+}
+
+func healthyReport() cdc.Report {
+	return cdc.Report{
+		GeneratedAt: generatedAt,
+		Results: []cdc.CheckResult{
+			{Label: "ASI", DisplayName: "ASI", Check: cdc.CheckOfflineNUCs},
+		},
+	}
+}
+
+func change(status state.Status) state.Change {
+	return state.Change{
+		Entry: state.Entry{
+			Instance:  "ASI",
+			Check:     cdc.CheckOfflineNUCs,
+			Tenant:    "Acme Health",
+			Severity:  cdc.SeverityCritical,
+			FirstSeen: generatedAt.Add(-3 * time.Hour),
+			LastSeen:  generatedAt,
+		},
+		Status: status,
+	}
+}
+
+func TestNotifySlack(t *testing.T) {
+	w := &webhook{}
+	n := newNotifier(t, w, FormatSlack, ModeAlways)
+
+	posted, err := n.Notify(context.Background(), unhealthyReport(), []state.Change{change(state.StatusNew)})
+
+	if err != nil || !posted {
+		t.Fatalf("got (%t, %v), want (true, nil)", posted, err)
+	}
+
+	var got slackPayload
+	w.decode(t, &got)
+
+	const header = "CDC Install/Replication status (2020-05-01 12:00 UTC)"
+
+	want := slackPayload{
+		Text: header,
+		Blocks: []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: header}},
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "*ASI NUCs offline:*\n• Acme Health (offline for 3.0 hours) [NEW]"}},
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "*ASI NUCs or VMs online but not replicating:* none"}},
+			{Type: "section", Text: &slackText{Type: "mrkdwn", Text: "*UNKNOWN (data could not be retrieved):*\n• ASI NUCs or VMs online but not replicating: metricly: connection refused"}},
+		},
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got payload %+v, want %+v", got, want)
+	}
+}
+
+func TestNotifyTeams(t *testing.T) {
+	tests := []struct {
+		name    string
+		report  cdc.Report
+		changes []state.Change
+		want    teamsPayload
+	}{
+		{
+			name:    "unhealthy",
+			report:  unhealthyReport(),
+			changes: []state.Change{change(state.StatusOngoing)},
+			want: teamsPayload{
+				ThemeColor: teamsColorUnhealthy,
+				Sections: []teamsSection{
+					{ActivityTitle: "ASI NUCs offline", Text: "- Acme Health (offline for 3.0 hours)"},
+					{ActivityTitle: "ASI NUCs or VMs online but not replicating", Text: "none"},
+					{ActivityTitle: "UNKNOWN (data could not be retrieved)", Text: "- ASI NUCs or VMs online but not replicating: metricly: connection refused"},
+				},
+			},
+		},
+		{
+			name:    "recovered",
+			report:  healthyReport(),
+			changes: []state.Change{change(state.StatusRecovered)},
+			want: teamsPayload{
+				ThemeColor: teamsColorHealthy,
+				Sections: []teamsSection{
+					{ActivityTitle: "ASI NUCs offline", Text: "none"},
+					{ActivityTitle: "Recovered since the last run", Text: "- Acme Health (ASI offline)"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &webhook{}
+			n := newNotifier(t, w, FormatTeams, ModeAlways)
+
+			if _, err := n.Notify(context.Background(), tt.report, tt.changes); err != nil {
+				t.Fatalf("Notify returned error: %v", err)
+			}
+
+			var got teamsPayload
+			w.decode(t, &got)
+
+			tt.want.Type = "MessageCard"
+			tt.want.Context = "https://schema.org/extensions"
+			tt.want.Summary = "CDC Install/Replication status (2020-05-01 12:00 UTC)"
+			tt.want.Title = tt.want.Summary
+
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got payload %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotifyChangedMode(t *testing.T) {
+	// each step is a run, in order, against the same Notifier
+	type step struct {
+		report  cdc.Report
+		changes []state.Change
+		want    bool
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"new finding", []step{
+			{healthyReport(), []state.Change{}, false},
+			{unhealthyReport(), []state.Change{change(state.StatusNew)}, true},
+		}},
+		{"ongoing findings and errors", []step{
+			{unhealthyReport(), []state.Change{change(state.StatusNew)}, true},
+			{unhealthyReport(), []state.Change{change(state.StatusOngoing)}, false},
+		}},
+		{"new error", []step{
+			{healthyReport(), []state.Change{}, false},
+			{cdc.Report{GeneratedAt: generatedAt, Results: unhealthyReport().Results[1:]}, []state.Change{}, true},
+			{cdc.Report{GeneratedAt: generatedAt, Results: unhealthyReport().Results[1:]}, []state.Change{}, false},
+		}},
+		{"recovered", []step{
+			{healthyReport(), []state.Change{change(state.StatusRecovered)}, true},
+			{healthyReport(), []state.Change{}, false},
+		}},
+		{"without state", []step{
+			{unhealthyReport(), nil, true},
+			{unhealthyReport(), nil, true},
+			{healthyReport(), nil, true},
+			{healthyReport(), nil, false},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &webhook{}
+			n := newNotifier(t, w, FormatSlack, ModeChanged)
+
+			want := 0
+
+			for i, s := range tt.steps {
+				posted, err := n.Notify(context.Background(), s.report, s.changes)
+
+				if err != nil {
+					t.Fatalf("run %d: Notify returned error: %v", i+1, err)
+				}
+
+				if posted != s.want {
+					t.Errorf("run %d: got posted %t, want %t", i+1, posted, s.want)
+				}
+
+				if s.want {
+					want++
+				}
+			}
+
+			if got := w.posts(); got != want {
+				t.Errorf("webhook got %d posts, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestNotifyWebhookError(t *testing.T) {
+	w := &webhook{status: http.StatusInternalServerError}
+	n := newNotifier(t, w, FormatSlack, ModeAlways)
+
+	posted, err := n.Notify(context.Background(), unhealthyReport(), nil)
+
+	if posted {
+		t.Error("got posted true, want false")
+	}
+
+	if err == nil || !strings.Contains(err.Error(), "webhook returned 500 Internal Server Error: no_text") {
+		t.Errorf("got error %v, want one containing the webhook's status and reply", err)
+	}
+}
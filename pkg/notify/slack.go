@@ -0,0 +1,57 @@
+package notify
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+	"github.com/michaelmosher/monitoring/pkg/state"
+)
+
+// Slack rejects section blocks with more than 3000 characters of text.
+const slackMaxSectionText = 3000
+
+type slackText struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type slackBlock struct {
+	Type string     `json:"type"`
+	Text *slackText `json:"text,omitempty"`
+}
+
+type slackPayload struct {
+	Text   string       `json:"text"`
+	Blocks []slackBlock `json:"blocks"`
+}
+
+// renderSlack renders a report as a Slack Block Kit message.
+func renderSlack(report cdc.Report, changes []state.Change) slackPayload {
+	payload := slackPayload{
+		Text: title(report),
+		Blocks: []slackBlock{
+			{Type: "header", Text: &slackText{Type: "plain_text", Text: title(report)}},
+		},
+	}
+
+	for _, s := range sections(report, changes) {
+		text := fmt.Sprintf("*%s:* none", s.title)
+
+		if len(s.lines) > 0 {
+			text = fmt.Sprintf("*%s:*\n• %s", s.title, strings.Join(s.lines, "\n• "))
+		}
+
+		if len(text) > slackMaxSectionText {
+			// "…" is three bytes; drop any rune split by the cut
+			text = strings.ToValidUTF8(text[:slackMaxSectionText-3], "") + "…"
+		}
+
+		payload.Blocks = append(payload.Blocks, slackBlock{
+			Type: "section",
+			Text: &slackText{Type: "mrkdwn", Text: text},
+		})
+	}
+
+	return payload
+}
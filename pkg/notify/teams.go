@@ -0,0 +1,55 @@
+package notify
+
+import (
+	"strings"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+	"github.com/michaelmosher/monitoring/pkg/state"
+)
+
+const (
+	teamsColorHealthy   = "2EB886"
+	teamsColorUnhealthy = "D00000"
+)
+
+type teamsSection struct {
+	ActivityTitle string `json:"activityTitle"`
+	Text          string `json:"text"`
+}
+
+// teamsPayload is an Office 365 connector "MessageCard".
+type teamsPayload struct {
+	Type       string         `json:"@type"`
+	Context    string         `json:"@context"`
+	Summary    string         `json:"summary"`
+	ThemeColor string         `json:"themeColor"`
+	Title      string         `json:"title"`
+	Sections   []teamsSection `json:"sections"`
+}
+
+// renderTeams renders a report as a Microsoft Teams message card.
+func renderTeams(report cdc.Report, changes []state.Change) teamsPayload {
+	payload := teamsPayload{
+		Type:       "MessageCard",
+		Context:    "https://schema.org/extensions",
+		Summary:    title(report),
+		ThemeColor: teamsColorHealthy,
+		Title:      title(report),
+	}
+
+	if !healthy(report) {
+		payload.ThemeColor = teamsColorUnhealthy
+	}
+
+	for _, s := range sections(report, changes) {
+		text := "none"
+
+		if len(s.lines) > 0 {
+			text = "- " + strings.Join(s.lines, "\n- ")
+		}
+
+		payload.Sections = append(payload.Sections, teamsSection{ActivityTitle: s.title, Text: text})
+	}
+
+	return payload
+}
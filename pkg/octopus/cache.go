@@ -0,0 +1,170 @@
+package octopus
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Cache is a client that remembers the machines, tenants and projects
+// fetched by another client for TTL, so that checks run against the same
+// instance share one download of each. Concurrent requests for the same data
+// wait for a single fetch. Errors are not cached.
+//
+// Results are shared between callers, so they must not be modified.
+// Everything else, such as events and tasks, is passed straight through.
+type Cache struct {
+	client client
+	ttl    time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cacheEntry
+}
+
+type cacheEntry struct {
+	mu      sync.Mutex
+	value   interface{}
+	fetched time.Time
+}
+
+// NewCache wraps client, caching what it returns for ttl. Use it as the
+// client of a Service:
+//
+//	octopus.New(octopus.NewCache(octopus_http.New(...), time.Minute))
+func NewCache(client client, ttl time.Duration) *Cache {
+	return &Cache{
+		client:  client,
+		ttl:     ttl,
+		entries: make(map[string]*cacheEntry),
+	}
+}
+
+func (c *Cache) get(key string, fetch func() (interface{}, error)) (interface{}, error) {
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if !ok {
+		e = &cacheEntry{}
+		c.entries[key] = e
+	}
+	c.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if !e.fetched.IsZero() && time.Since(e.fetched) < c.ttl {
+		return e.value, nil
+	}
+
+	value, err := fetch()
+
+	if err != nil {
+		return nil, err
+	}
+
+	e.value, e.fetched = value, time.Now()
+
+	return value, nil
+}
+
+// invalidate forgets key, so the next request for it is fetched again.
+func (c *Cache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+func (c *Cache) FetchMachines(ctx context.Context) ([]Machine, error) {
+	v, err := c.get("machines", func() (interface{}, error) {
+		return c.client.FetchMachines(ctx)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]Machine), nil
+}
+
+func (c *Cache) FetchMachine(ctx context.Context, machineID string) (Machine, error) {
+	return c.client.FetchMachine(ctx, machineID)
+}
+
+func (c *Cache) FetchProjects(ctx context.Context) ([]Project, error) {
+	v, err := c.get("projects", func() (interface{}, error) {
+		return c.client.FetchProjects(ctx)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]Project), nil
+}
+
+func (c *Cache) FetchProject(ctx context.Context, projectID string) (Project, error) {
+	v, err := c.get("project/"+projectID, func() (interface{}, error) {
+		return c.client.FetchProject(ctx, projectID)
+	})
+
+	if err != nil {
+		return Project{}, err
+	}
+
+	return v.(Project), nil
+}
+
+func (c *Cache) FetchTenants(ctx context.Context) ([]Tenant, error) {
+	v, err := c.get("tenants", func() (interface{}, error) {
+		return c.client.FetchTenants(ctx)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return v.([]Tenant), nil
+}
+
+func (c *Cache) FetchTenant(ctx context.Context, tenantID string) (Tenant, error) {
+	return c.client.FetchTenant(ctx, tenantID)
+}
+
+func (c *Cache) FetchEvents(ctx context.Context, filter map[string]string, limit int) ([]Event, error) {
+	return c.client.FetchEvents(ctx, filter, limit)
+}
+
+func (c *Cache) FetchReleases(ctx context.Context, projectID string, limit int) ([]Release, error) {
+	return c.client.FetchReleases(ctx, projectID, limit)
+}
+
+func (c *Cache) FetchDeployments(ctx context.Context, filter map[string]string, limit int) ([]Deployment, error) {
+	return c.client.FetchDeployments(ctx, filter, limit)
+}
+
+func (c *Cache) FetchDashboard(ctx context.Context, projectIDs []string) ([]DashboardItem, error) {
+	return c.client.FetchDashboard(ctx, projectIDs)
+}
+
+func (c *Cache) CreateHealthCheckTask(ctx context.Context, machineIDs []string) (Task, error) {
+	return c.client.CreateHealthCheckTask(ctx, machineIDs)
+}
+
+// FetchTask forgets the cached machines once a task completes, since a
+// health check changes their status.
+func (c *Cache) FetchTask(ctx context.Context, taskID string) (Task, error) {
+	task, err := c.client.FetchTask(ctx, taskID)
+
+	if err == nil && task.IsCompleted {
+		c.invalidate("machines")
+	}
+
+	return task, err
+}
+
+func (c *Cache) SetMachineDisabled(ctx context.Context, machineID string, disabled bool) (Machine, error) {
+	machine, err := c.client.SetMachineDisabled(ctx, machineID, disabled)
+	c.invalidate("machines")
+
+	return machine, err
+}
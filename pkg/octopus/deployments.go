@@ -0,0 +1,69 @@
+package octopus
+
+import (
+	"context"
+	"time"
+)
+
+// Release is a version of a project that can be deployed.
+type Release struct {
+	ID        string `json:"Id"`
+	Version   string
+	ProjectID string `json:"ProjectId"`
+	Assembled time.Time
+}
+
+// Deployment is a release deployed to an environment, and possibly a tenant.
+// TaskID is the server task that ran it.
+type Deployment struct {
+	ID            string `json:"Id"`
+	Name          string
+	ReleaseID     string `json:"ReleaseId"`
+	ProjectID     string `json:"ProjectId"`
+	EnvironmentID string `json:"EnvironmentId"`
+	TenantID      string `json:"TenantId"`
+	TaskID        string `json:"TaskId"`
+	Created       time.Time
+}
+
+// DashboardItem is the latest deployment of a project to an environment and
+// tenant, with the state of the task that ran it.
+type DashboardItem struct {
+	ProjectID           string `json:"ProjectId"`
+	EnvironmentID       string `json:"EnvironmentId"`
+	TenantID            string `json:"TenantId"`
+	ReleaseID           string `json:"ReleaseId"`
+	ReleaseVersion      string
+	DeploymentID        string `json:"DeploymentId"`
+	TaskID              string `json:"TaskId"`
+	State               TaskState
+	ErrorMessage        string
+	IsCompleted         bool
+	HasWarningsOrErrors bool
+	Created             time.Time
+	CompletedTime       *time.Time
+}
+
+// Failed reports whether the deployment finished unsuccessfully.
+func (d DashboardItem) Failed() bool {
+	return d.State == TaskFailed || d.State == TaskTimedOut || d.State == TaskCanceled
+}
+
+// FetchReleases returns a project's releases, newest first, or at most limit
+// releases if limit is positive.
+func (s Service) FetchReleases(ctx context.Context, projectID string, limit int) ([]Release, error) {
+	return s.client.FetchReleases(ctx, projectID, limit)
+}
+
+// FetchDeployments returns the deployments matching filter (e.g. "projects"
+// and "tenants", as comma-separated IDs), newest first, or at most limit
+// deployments if limit is positive.
+func (s Service) FetchDeployments(ctx context.Context, filter map[string]string, limit int) ([]Deployment, error) {
+	return s.client.FetchDeployments(ctx, filter, limit)
+}
+
+// FetchDashboard returns the latest deployment of each of the given projects
+// to every environment and tenant.
+func (s Service) FetchDashboard(ctx context.Context, projectIDs []string) ([]DashboardItem, error) {
+	return s.client.FetchDashboard(ctx, projectIDs)
+}
@@ -0,0 +1,114 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/michaelmosher/monitoring/pkg/octopus"
+)
+
+// FetchReleases returns a project's releases, newest first, following the
+// "Page.Next" link until limit releases (if positive) have been collected.
+func (s Service) FetchReleases(ctx context.Context, projectID string, limit int) ([]octopus.Release, error) {
+	if projectID == "" {
+		return nil, fmt.Errorf("no project ID")
+	}
+
+	releases := []octopus.Release{}
+
+	err := s.fetchCollection(ctx, fmt.Sprintf("projects/%s/releases", projectID), "releases", func(items json.RawMessage) (int, error) {
+		var page []octopus.Release
+		if err := json.Unmarshal(items, &page); err != nil {
+			return 0, err
+		}
+
+		releases = append(releases, page...)
+		return len(page), nil
+	}, func() bool {
+		return limit > 0 && len(releases) >= limit
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && len(releases) > limit {
+		releases = releases[:limit]
+	}
+
+	return releases, nil
+}
+
+// FetchDeployments returns the deployments matching filter, newest first,
+// following the "Page.Next" link until limit deployments (if positive) have
+// been collected.
+func (s Service) FetchDeployments(ctx context.Context, filter map[string]string, limit int) ([]octopus.Deployment, error) {
+	query := url.Values{}
+
+	for key, value := range filter {
+		query.Set(key, value)
+	}
+
+	deployments := []octopus.Deployment{}
+
+	err := s.fetchCollection(ctx, fmt.Sprintf("deployments?%s", query.Encode()), "deployments", func(items json.RawMessage) (int, error) {
+		var page []octopus.Deployment
+		if err := json.Unmarshal(items, &page); err != nil {
+			return 0, err
+		}
+
+		deployments = append(deployments, page...)
+		return len(page), nil
+	}, func() bool {
+		return limit > 0 && len(deployments) >= limit
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && len(deployments) > limit {
+		deployments = deployments[:limit]
+	}
+
+	return deployments, nil
+}
+
+// FetchDashboard returns the latest deployment of each of the given projects
+// to every environment and tenant.
+func (s Service) FetchDashboard(ctx context.Context, projectIDs []string) ([]octopus.DashboardItem, error) {
+	query := url.Values{}
+	query.Set("projects", strings.Join(projectIDs, ","))
+	query.Set("includePrevious", "false")
+
+	req, err := s.createDataRequest(ctx, fmt.Sprintf("dashboard/dynamic?%s", query.Encode()))
+
+	if err != nil {
+		return nil, fmt.Errorf("error creating API request: %v", err)
+	}
+
+	resp, err := s.httpClient.Do(req)
+
+	if err != nil {
+		return nil, fmt.Errorf("error executing API request: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return nil, handleErrorResponse(resp, "dashboard")
+	}
+
+	defer resp.Body.Close()
+
+	var d struct {
+		Items []octopus.DashboardItem
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&d); err != nil {
+		return nil, fmt.Errorf("Error decoding JSON: %v", err)
+	}
+
+	return d.Items, nil
+}
@@ -1,9 +1,10 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"net/url"
 
 	"github.com/michaelmosher/monitoring/pkg/octopus"
 )
@@ -12,41 +13,37 @@ type EventsResponse struct {
 	Events []octopus.Event `json:"Items"`
 }
 
-func (s Service) FetchEvents(filter map[string]string) ([]octopus.Event, error) {
-	queryString := ""
+// FetchEvents returns the events matching filter, following the "Page.Next"
+// link until every page has been read. A positive limit stops paging once
+// that many events have been collected.
+func (s Service) FetchEvents(ctx context.Context, filter map[string]string, limit int) ([]octopus.Event, error) {
+	query := url.Values{}
 
 	for key, value := range filter {
-		queryString = fmt.Sprintf("%s&%s=%s", queryString, key, value)
+		query.Set(key, value)
 	}
 
-	req, err := s.createDataRequest(fmt.Sprintf("events?%s", queryString))
+	events := []octopus.Event{}
 
-	if err != nil {
-		return nil, fmt.Errorf("error creating API request: %v", err)
-	}
+	err := s.fetchCollection(ctx, fmt.Sprintf("events?%s", query.Encode()), "events", func(items json.RawMessage) (int, error) {
+		var page []octopus.Event
+		if err := json.Unmarshal(items, &page); err != nil {
+			return 0, err
+		}
 
-	resp, err := s.httpClient.Do(req)
+		events = append(events, page...)
+		return len(page), nil
+	}, func() bool {
+		return limit > 0 && len(events) >= limit
+	})
 
 	if err != nil {
-		return nil, fmt.Errorf("error executing API request: %v", err)
+		return nil, err
 	}
 
-	if resp.StatusCode != 200 {
-		return nil, handleErrorResponse(resp, "events")
-	}
-
-	return handleEventsResponse(resp)
-}
-
-func handleEventsResponse(resp *http.Response) ([]octopus.Event, error) {
-	defer resp.Body.Close()
-
-	var e EventsResponse
-	err := json.NewDecoder(resp.Body).Decode(&e)
-
-	if err != nil {
-		return nil, fmt.Errorf("Error decoding JSON: %v", err)
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
 	}
 
-	return e.Events, nil
+	return events, nil
 }
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -8,8 +9,8 @@ import (
 	"github.com/michaelmosher/monitoring/pkg/octopus"
 )
 
-func (s Service) FetchMachines() ([]octopus.Machine, error) {
-	req, err := s.createDataRequest("machines/all")
+func (s Service) FetchMachines(ctx context.Context) ([]octopus.Machine, error) {
+	req, err := s.createDataRequest(ctx, "machines/all")
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating API request: %v", err)
@@ -25,17 +26,17 @@ func (s Service) FetchMachines() ([]octopus.Machine, error) {
 		return nil, handleErrorResponse(resp, "machines")
 	}
 
-	return handleMachinesResponse(resp)
+	return s.handleMachinesResponse(resp)
 }
 
-func (s Service) FetchMachine(machineID string) (octopus.Machine, error) {
+func (s Service) FetchMachine(ctx context.Context, machineID string) (octopus.Machine, error) {
 	var m octopus.Machine
 
 	if machineID == "" || machineID == "all" {
 		return m, fmt.Errorf("no u. Use FetchMachines instead")
 	}
 
-	req, err := s.createDataRequest(fmt.Sprintf("machines/%s", machineID))
+	req, err := s.createDataRequest(ctx, fmt.Sprintf("machines/%s", machineID))
 
 	if err != nil {
 		return m, fmt.Errorf("error creating API request: %v", err)
@@ -54,23 +55,83 @@ func (s Service) FetchMachine(machineID string) (octopus.Machine, error) {
 	return m, handleMachineResponse(resp, &m)
 }
 
-func handleMachinesResponse(resp *http.Response) ([]octopus.Machine, error) {
-	defer resp.Body.Close()
+// SetMachineDisabled disables or re-enables a machine. Octopus replaces the
+// whole resource on update, so the machine is read and written back as-is,
+// apart from IsDisabled.
+func (s Service) SetMachineDisabled(ctx context.Context, machineID string, disabled bool) (octopus.Machine, error) {
+	var m octopus.Machine
+
+	if machineID == "" || machineID == "all" {
+		return m, fmt.Errorf("no machine ID")
+	}
+
+	path := fmt.Sprintf("machines/%s", machineID)
+	req, err := s.createDataRequest(ctx, path)
+
+	if err != nil {
+		return m, fmt.Errorf("error creating API request: %v", err)
+	}
+
+	resp, err := s.httpClient.Do(req)
+
+	if err != nil {
+		return m, fmt.Errorf("error executing API request: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return m, handleErrorResponse(resp, "machine")
+	}
+
+	var resource map[string]interface{}
+
+	if err := handleMachineResponse(resp, &resource); err != nil {
+		return m, err
+	}
+
+	resource["IsDisabled"] = disabled
+
+	req, err = s.createWriteRequest(ctx, "PUT", path, resource)
+
+	if err != nil {
+		return m, fmt.Errorf("error creating API request: %v", err)
+	}
+
+	resp, err = s.httpClient.Do(req)
+
+	if err != nil {
+		return m, fmt.Errorf("error executing API request: %v", err)
+	}
 
-	type machineList struct {
-		machines []octopus.Machine
+	if resp.StatusCode != 200 {
+		return m, handleErrorResponse(resp, "machine")
 	}
 
-	list := machineList{}
-	err := json.NewDecoder(resp.Body).Decode(&list.machines)
+	return m, handleMachineResponse(resp, &m)
+}
+
+func (s Service) handleMachinesResponse(resp *http.Response) ([]octopus.Machine, error) {
+	defer resp.Body.Close()
+
+	list := make([]octopus.Machine, 0)
+
+	err := s.decodeList(resp, func(raw json.RawMessage) error {
+		var m octopus.Machine
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return err
+		}
+
+		list = append(list, m)
+		return nil
+	})
+
 	if err != nil {
-		return nil, fmt.Errorf("Error decoding JSON: %v", err)
+		return nil, err
 	}
 
-	return list.machines, nil
+	return list, nil
 }
 
-func handleMachineResponse(resp *http.Response, m *octopus.Machine) error {
+func handleMachineResponse(resp *http.Response, m interface{}) error {
 	defer resp.Body.Close()
 
 	err := json.NewDecoder(resp.Body).Decode(m)
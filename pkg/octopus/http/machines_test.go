@@ -0,0 +1,124 @@
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const goodMachine = `{"Id": "Machines-1", "Name": "nuc-01", "HealthStatus": "Healthy", "Roles": ["side-server-appliances"]}`
+
+// listResponse returns a 200 response whose body is records as a JSON array.
+func listResponse(records ...string) *http.Response {
+	return jsonResponse([]byte(fmt.Sprintf("[%s]", strings.Join(records, ","))))
+}
+
+func jsonResponse(body []byte) *http.Response {
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+	}
+}
+
+var machineTests = []struct {
+	name   string
+	record string
+	// wantErr is part of the error for record, or empty if it's valid.
+	wantErr string
+}{
+	{"valid", `{"Id": "Machines-2", "HealthStatus": "Unavailable"}`, ""},
+	{"null fields", `{"Id": "Machines-2", "HealthStatus": null, "Roles": null, "TenantIds": null, "Endpoint": null}`, ""},
+	{"roles not a list", `{"Id": "Machines-2", "Roles": "side-server-appliances"}`, "machine Machines-2:"},
+	{"role not a string", `{"Id": "Machines-2", "Roles": [1]}`, "machine Machines-2:"},
+	{"unknown health status", `{"Id": "Machines-2", "HealthStatus": "Grumpy"}`, `machine Machines-2: unknown HealthStatus "Grumpy"`},
+	{"endpoint not an object", `{"Id": "Machines-2", "Endpoint": "poll://abc123/"}`, "machine Machines-2:"},
+	{"bad health check time", `{"Id": "Machines-2", "HealthLastChecked": "yesterday"}`, "machine Machines-2:"},
+	{"no Id", `{"Name": "nuc-02", "HealthStatus": "Healthy"}`, "machine without an Id"},
+	{"Id not a string", `{"Id": 2, "Name": "nuc-02"}`, "machine (unknown Id):"},
+}
+
+func TestHandleMachinesResponse(t *testing.T) {
+	for _, tt := range machineTests {
+		t.Run(tt.name, func(t *testing.T) {
+			machines, err := Service{}.handleMachinesResponse(listResponse(goodMachine, tt.record))
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+
+				if len(machines) != 2 {
+					t.Errorf("got %d machines, want 2", len(machines))
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleMachinesResponseSkipsInvalidRecords(t *testing.T) {
+	for _, tt := range machineTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var skipped []error
+
+			s := Service{OnInvalidRecord: func(err error) {
+				skipped = append(skipped, err)
+			}}
+
+			machines, err := s.handleMachinesResponse(listResponse(goodMachine, tt.record, goodMachine))
+
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			want := 3
+			if tt.wantErr != "" {
+				want = 2
+
+				if len(skipped) != 1 || !strings.Contains(skipped[0].Error(), tt.wantErr) {
+					t.Errorf("skipped %v, want one error containing %q", skipped, tt.wantErr)
+				}
+			}
+
+			if len(machines) != want {
+				t.Errorf("got %d machines, want %d", len(machines), want)
+			}
+
+			for _, m := range machines {
+				if m.ID == "" {
+					t.Errorf("got a machine without an ID: %+v", m)
+				}
+			}
+		})
+	}
+}
+
+func FuzzHandleMachinesResponse(f *testing.F) {
+	for _, tt := range machineTests {
+		f.Add([]byte(fmt.Sprintf("[%s,%s]", goodMachine, tt.record)))
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		s := Service{OnInvalidRecord: func(err error) {}}
+
+		machines, err := s.handleMachinesResponse(jsonResponse(body))
+
+		if err != nil {
+			return
+		}
+
+		for _, m := range machines {
+			if m.ID == "" {
+				t.Errorf("got a machine without an ID: %+v", m)
+			}
+		}
+	})
+}
@@ -1,8 +1,11 @@
 package http
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 )
 
@@ -10,15 +13,29 @@ type httpDoer interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
+// collectionResponse is one page of a paged collection, e.g. events or
+// releases. Its Items are decoded by the caller.
+type collectionResponse struct {
+	Items json.RawMessage   `json:"Items"`
+	Links map[string]string `json:"Links"`
+}
+
 type errorResponse struct {
 	StatusCode   int
 	ErrorMessage string
 }
 
 type Service struct {
-	httpClient httpDoer
-	apiBaseURL string
-	apiKey     string
+	httpClient  httpDoer
+	instanceURL string
+	apiBaseURL  string
+	space       string
+	apiKey      string
+
+	// OnInvalidRecord, if set, makes FetchMachines and FetchTenants skip any
+	// record that fails to decode, calling OnInvalidRecord with the error
+	// instead of failing the whole request.
+	OnInvalidRecord func(err error)
 }
 
 // New creates an instance of an Octopus client, ready to call some APIs.
@@ -26,17 +43,51 @@ type Service struct {
 // to obtain an instance.
 func New(doer httpDoer, instanceURL string, space string, apiKey string) Service {
 	return Service{
-		httpClient: doer,
-		apiBaseURL: fmt.Sprintf("%s/api/%s", instanceURL, space),
-		apiKey:     apiKey,
+		httpClient:  doer,
+		instanceURL: instanceURL,
+		apiBaseURL:  fmt.Sprintf("%s/api/%s", instanceURL, space),
+		space:       space,
+		apiKey:      apiKey,
+	}
+}
+
+func (s Service) createDataRequest(ctx context.Context, url string) (*http.Request, error) {
+	return s.createRequest(ctx, fmt.Sprintf("%s/%s", s.apiBaseURL, url))
+}
+
+// createLinkRequest follows one of the "Links" returned by the API, which are
+// paths relative to the instance (e.g. "/api/Spaces-1/events?skip=30").
+func (s Service) createLinkRequest(ctx context.Context, link string) (*http.Request, error) {
+	return s.createRequest(ctx, fmt.Sprintf("%s%s", s.instanceURL, link))
+}
+
+func (s Service) createRequest(ctx context.Context, url string) (*http.Request, error) {
+	return s.createBodyRequest(ctx, "GET", url, nil)
+}
+
+// createWriteRequest is like createDataRequest, but sends body as JSON with
+// the given method.
+func (s Service) createWriteRequest(ctx context.Context, method string, url string, body interface{}) (*http.Request, error) {
+	data, err := json.Marshal(body)
+
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling JSON: %v", err)
 	}
+
+	return s.createBodyRequest(ctx, method, fmt.Sprintf("%s/%s", s.apiBaseURL, url), data)
 }
 
-func (s Service) createDataRequest(url string) (*http.Request, error) {
-	req, err := http.NewRequest(
-		"GET",
-		fmt.Sprintf("%s/%s", s.apiBaseURL, url),
-		nil,
+func (s Service) createBodyRequest(ctx context.Context, method string, url string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		method,
+		url,
+		reader,
 	)
 
 	if err != nil {
@@ -62,3 +113,92 @@ func handleErrorResponse(resp *http.Response, caller string) error {
 
 	return fmt.Errorf("Error retrieving %s data: %+v", caller, e)
 }
+
+// decodeList decodes a JSON array one element at a time, passing each to
+// decode. A bad element fails the whole list unless s.OnInvalidRecord is set.
+func (s Service) decodeList(resp *http.Response, decode func(json.RawMessage) error) error {
+	dec := json.NewDecoder(resp.Body)
+
+	if _, err := dec.Token(); err != nil {
+		return fmt.Errorf("Error decoding JSON: %v", err)
+	}
+
+	for dec.More() {
+		var raw json.RawMessage
+
+		if err := dec.Decode(&raw); err != nil {
+			return fmt.Errorf("Error decoding JSON: %v", err)
+		}
+
+		if err := decode(raw); err != nil {
+			if s.OnInvalidRecord == nil {
+				return fmt.Errorf("Error decoding JSON: %v", err)
+			}
+
+			s.OnInvalidRecord(err)
+		}
+	}
+
+	// throw away closing ']'
+	dec.Token()
+
+	return nil
+}
+
+// fetchCollection reads a paged collection starting at path, passing each
+// page's Items to add, until there are no more pages or done returns true.
+func (s Service) fetchCollection(ctx context.Context, path string, caller string, add func(json.RawMessage) (int, error), done func() bool) error {
+	req, err := s.createDataRequest(ctx, path)
+
+	if err != nil {
+		return fmt.Errorf("error creating API request: %v", err)
+	}
+
+	for {
+		page, err := s.fetchCollectionPage(req, caller)
+
+		if err != nil {
+			return err
+		}
+
+		n, err := add(page.Items)
+
+		if err != nil {
+			return fmt.Errorf("Error decoding JSON: %v", err)
+		}
+
+		next := page.Links["Page.Next"]
+
+		if done() || next == "" || n == 0 {
+			return nil
+		}
+
+		req, err = s.createLinkRequest(ctx, next)
+
+		if err != nil {
+			return fmt.Errorf("error creating API request: %v", err)
+		}
+	}
+}
+
+func (s Service) fetchCollectionPage(req *http.Request, caller string) (collectionResponse, error) {
+	resp, err := s.httpClient.Do(req)
+
+	if err != nil {
+		return collectionResponse{}, fmt.Errorf("error executing API request: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return collectionResponse{}, handleErrorResponse(resp, caller)
+	}
+
+	defer resp.Body.Close()
+
+	var c collectionResponse
+
+	if err := json.NewDecoder(resp.Body).Decode(&c); err != nil {
+		return c, fmt.Errorf("Error decoding JSON: %v", err)
+	}
+
+	return c, nil
+}
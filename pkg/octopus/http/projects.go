@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -8,8 +9,8 @@ import (
 	"github.com/michaelmosher/monitoring/pkg/octopus"
 )
 
-func (s Service) FetchProjects() ([]octopus.Project, error) {
-	req, err := s.createDataRequest("projects/all")
+func (s Service) FetchProjects(ctx context.Context) ([]octopus.Project, error) {
+	req, err := s.createDataRequest(ctx, "projects/all")
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating API request: %v", err)
@@ -29,14 +30,14 @@ func (s Service) FetchProjects() ([]octopus.Project, error) {
 
 }
 
-func (s Service) FetchProject(projectID string) (octopus.Project, error) {
+func (s Service) FetchProject(ctx context.Context, projectID string) (octopus.Project, error) {
 	var p octopus.Project
 
 	if projectID == "" || projectID == "all" {
 		return p, fmt.Errorf("no u. Use FetchProjects instead")
 	}
 
-	req, err := s.createDataRequest(fmt.Sprintf("projects/%s", projectID))
+	req, err := s.createDataRequest(ctx, fmt.Sprintf("projects/%s", projectID))
 
 	if err != nil {
 		return p, fmt.Errorf("error creating API request: %v", err)
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/michaelmosher/monitoring/pkg/octopus"
+)
+
+type healthCheckTask struct {
+	Name        string
+	Description string
+	SpaceID     string `json:"SpaceId"`
+	Arguments   healthCheckArguments
+}
+
+type healthCheckArguments struct {
+	Timeout        string
+	MachineTimeout string
+	MachineIDs     []string `json:"MachineIds"`
+}
+
+// CreateHealthCheckTask queues a health check of the given machines.
+func (s Service) CreateHealthCheckTask(ctx context.Context, machineIDs []string) (octopus.Task, error) {
+	var t octopus.Task
+
+	if len(machineIDs) == 0 {
+		return t, fmt.Errorf("no machines to check")
+	}
+
+	req, err := s.createWriteRequest(ctx, "POST", "tasks", healthCheckTask{
+		Name:        "Health",
+		Description: fmt.Sprintf("Check health of %d machine(s)", len(machineIDs)),
+		SpaceID:     s.space,
+		Arguments: healthCheckArguments{
+			Timeout:        "00:05:00",
+			MachineTimeout: "00:05:00",
+			MachineIDs:     machineIDs,
+		},
+	})
+
+	if err != nil {
+		return t, fmt.Errorf("error creating API request: %v", err)
+	}
+
+	resp, err := s.httpClient.Do(req)
+
+	if err != nil {
+		return t, fmt.Errorf("error executing API request: %v", err)
+	}
+
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		return t, handleErrorResponse(resp, "task")
+	}
+
+	return t, handleTaskResponse(resp, &t)
+}
+
+func (s Service) FetchTask(ctx context.Context, taskID string) (octopus.Task, error) {
+	var t octopus.Task
+
+	if taskID == "" {
+		return t, fmt.Errorf("no task ID")
+	}
+
+	req, err := s.createDataRequest(ctx, fmt.Sprintf("tasks/%s", taskID))
+
+	if err != nil {
+		return t, fmt.Errorf("error creating API request: %v", err)
+	}
+
+	resp, err := s.httpClient.Do(req)
+
+	if err != nil {
+		return t, fmt.Errorf("error executing API request: %v", err)
+	}
+
+	if resp.StatusCode != 200 {
+		return t, handleErrorResponse(resp, "task")
+	}
+
+	return t, handleTaskResponse(resp, &t)
+}
+
+func handleTaskResponse(resp *http.Response, t *octopus.Task) error {
+	defer resp.Body.Close()
+
+	err := json.NewDecoder(resp.Body).Decode(t)
+
+	if err != nil {
+		return fmt.Errorf("Error decoding JSON: %v", err)
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package http
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -8,8 +9,8 @@ import (
 	"github.com/michaelmosher/monitoring/pkg/octopus"
 )
 
-func (s Service) FetchTenants() ([]octopus.Tenant, error) {
-	req, err := s.createDataRequest("tenantvariables/all")
+func (s Service) FetchTenants(ctx context.Context) ([]octopus.Tenant, error) {
+	req, err := s.createDataRequest(ctx, "tenantvariables/all")
 
 	if err != nil {
 		return nil, fmt.Errorf("error creating API request: %v", err)
@@ -17,25 +18,25 @@ func (s Service) FetchTenants() ([]octopus.Tenant, error) {
 
 	resp, err := s.httpClient.Do(req)
 
-	if resp.StatusCode != 200 {
-		return nil, handleErrorResponse(resp, "tenants")
-	}
-
 	if err != nil {
 		return nil, fmt.Errorf("error executing API request: %v", err)
 	}
 
-	return handleTenantsResponse(resp)
+	if resp.StatusCode != 200 {
+		return nil, handleErrorResponse(resp, "tenants")
+	}
+
+	return s.handleTenantsResponse(resp)
 }
 
-func (s Service) FetchTenant(tenantID string) (octopus.Tenant, error) {
+func (s Service) FetchTenant(ctx context.Context, tenantID string) (octopus.Tenant, error) {
 	var t octopus.Tenant
 
 	if tenantID == "" || tenantID == "all" {
 		return t, fmt.Errorf("no u. Use FetchTenants instead")
 	}
 
-	req, err := s.createDataRequest(fmt.Sprintf("tenants/%s", tenantID))
+	req, err := s.createDataRequest(ctx, fmt.Sprintf("tenants/%s", tenantID))
 
 	if err != nil {
 		return t, fmt.Errorf("error creating API request: %v", err)
@@ -43,39 +44,35 @@ func (s Service) FetchTenant(tenantID string) (octopus.Tenant, error) {
 
 	resp, err := s.httpClient.Do(req)
 
-	if resp.StatusCode != 200 {
-		return t, handleErrorResponse(resp, "tenant")
-	}
-
 	if err != nil {
 		return t, fmt.Errorf("error executing API request: %v", err)
 	}
 
+	if resp.StatusCode != 200 {
+		return t, handleErrorResponse(resp, "tenant")
+	}
+
 	return t, handleTenantResponse(resp, &t)
 }
 
-func handleTenantsResponse(resp *http.Response) ([]octopus.Tenant, error) {
+func (s Service) handleTenantsResponse(resp *http.Response) ([]octopus.Tenant, error) {
 	defer resp.Body.Close()
 
 	list := make([]octopus.Tenant, 0)
-	dec := json.NewDecoder(resp.Body)
 
-	// throw away opening '['
-	dec.Token()
-
-	for dec.More() {
+	err := s.decodeList(resp, func(raw json.RawMessage) error {
 		var t octopus.Tenant
-		err := dec.Decode(&t)
-
-		if err != nil {
-			return list, fmt.Errorf("Error decoding JSON: %v", err)
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return err
 		}
 
 		list = append(list, t)
-	}
+		return nil
+	})
 
-	// throw away closing ']'
-	dec.Token()
+	if err != nil {
+		return nil, err
+	}
 
 	return list, nil
 }
@@ -0,0 +1,117 @@
+package http
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+const goodTenant = `{
+	"Id": "TenantVariables-1",
+	"TenantId": "Tenants-1",
+	"TenantName": "Acme Health",
+	"ProjectVariables": {"Projects-1": {}},
+	"LibraryVariables": {
+		"LibraryVariableSets-1": {
+			"Templates": [{"Id": "uaid", "Name": "UAID"}],
+			"Variables": {"uaid": "UA0001"}
+		}
+	}
+}`
+
+var tenantTests = []struct {
+	name   string
+	record string
+	// wantErr is part of the error for record, or empty if it's valid.
+	wantErr string
+}{
+	{"tenant resource", `{"Id": "Tenants-2", "Name": "Bayside Clinic", "ProjectEnvironments": {"Projects-1": ["Environments-1"]}}`, ""},
+	{"sensitive variable", `{"TenantId": "Tenants-2", "LibraryVariables": {"lv": {"Templates": [{"Id": "pw", "Name": "Password"}], "Variables": {"pw": {"HasValue": true}}}}}`, ""},
+	{"null fields", `{"TenantId": "Tenants-2", "ProjectVariables": null, "LibraryVariables": null}`, ""},
+	{"projects not an object", `{"TenantId": "Tenants-2", "ProjectVariables": ["Projects-1"]}`, "tenant Tenants-2:"},
+	{"project environments not an object", `{"Id": "Tenants-2", "ProjectEnvironments": "Projects-1"}`, "tenant Tenants-2:"},
+	{"templates not a list", `{"TenantId": "Tenants-2", "LibraryVariables": {"lv": {"Templates": "UAID"}}}`, "tenant Tenants-2:"},
+	{"name not a string", `{"Id": "TenantVariables-2", "TenantId": "Tenants-2", "TenantName": ["Bayside"]}`, "tenant Tenants-2:"},
+	{"no Id", `{"TenantName": "Bayside Clinic"}`, "tenant without an Id"},
+}
+
+func TestHandleTenantsResponse(t *testing.T) {
+	for _, tt := range tenantTests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenants, err := Service{}.handleTenantsResponse(listResponse(goodTenant, tt.record))
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+
+				if len(tenants) != 2 {
+					t.Errorf("got %d tenants, want 2", len(tenants))
+				}
+
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("got error %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleTenantsResponseSkipsInvalidRecords(t *testing.T) {
+	for _, tt := range tenantTests {
+		t.Run(tt.name, func(t *testing.T) {
+			var skipped []error
+
+			s := Service{OnInvalidRecord: func(err error) {
+				skipped = append(skipped, err)
+			}}
+
+			tenants, err := s.handleTenantsResponse(listResponse(goodTenant, tt.record, goodTenant))
+
+			if err != nil {
+				t.Fatalf("got error %v, want none", err)
+			}
+
+			want := 3
+			if tt.wantErr != "" {
+				want = 2
+
+				if len(skipped) != 1 || !strings.Contains(skipped[0].Error(), tt.wantErr) {
+					t.Errorf("skipped %v, want one error containing %q", skipped, tt.wantErr)
+				}
+			}
+
+			if len(tenants) != want {
+				t.Errorf("got %d tenants, want %d", len(tenants), want)
+			}
+
+			if tenants[0].Variables["UAID"] != "UA0001" {
+				t.Errorf("got variables %v, want UAID UA0001", tenants[0].Variables)
+			}
+		})
+	}
+}
+
+func FuzzHandleTenantsResponse(f *testing.F) {
+	for _, tt := range tenantTests {
+		f.Add([]byte(fmt.Sprintf("[%s,%s]", goodTenant, tt.record)))
+	}
+
+	f.Fuzz(func(t *testing.T, body []byte) {
+		s := Service{OnInvalidRecord: func(err error) {}}
+
+		tenants, err := s.handleTenantsResponse(jsonResponse(body))
+
+		if err != nil {
+			return
+		}
+
+		for _, tenant := range tenants {
+			if tenant.ID == "" {
+				t.Errorf("got a tenant without an ID: %+v", tenant)
+			}
+		}
+	})
+}
@@ -1,36 +1,136 @@
 package octopus
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"time"
 )
 
+// HealthStatus is the result of a machine's last health check.
+type HealthStatus string
+
+const (
+	HealthHealthy     HealthStatus = "Healthy"
+	HealthHasWarnings HealthStatus = "HasWarnings"
+	HealthUnhealthy   HealthStatus = "Unhealthy"
+	HealthUnavailable HealthStatus = "Unavailable"
+	HealthUnknown     HealthStatus = "Unknown"
+)
+
+// ParseHealthStatus validates a HealthStatus returned by Octopus. An empty
+// status means the machine hasn't been checked, i.e. HealthUnknown.
+func ParseHealthStatus(s string) (HealthStatus, error) {
+	switch status := HealthStatus(s); status {
+	case HealthHealthy, HealthHasWarnings, HealthUnhealthy, HealthUnavailable, HealthUnknown:
+		return status, nil
+	case "":
+		return HealthUnknown, nil
+	default:
+		return "", fmt.Errorf("unknown HealthStatus %q", s)
+	}
+}
+
+// IsHealthy reports whether the last health check passed without warnings.
+func (h HealthStatus) IsHealthy() bool {
+	return h == HealthHealthy
+}
+
+// IsDegraded reports whether the machine was reachable, but its last health
+// check found problems.
+func (h HealthStatus) IsDegraded() bool {
+	return h == HealthHasWarnings || h == HealthUnhealthy
+}
+
+// IsUnavailable reports whether Octopus could not reach the machine.
+func (h HealthStatus) IsUnavailable() bool {
+	return h == HealthUnavailable
+}
+
+// IsReachable reports whether Octopus reached the machine during its last
+// health check, whatever it found.
+func (h HealthStatus) IsReachable() bool {
+	return h.IsHealthy() || h.IsDegraded()
+}
+
+// Machine is a deployment target. LastHealthCheck is zero if Octopus didn't
+// report when the machine was last checked.
 type Machine struct {
 	ID        string
 	Name      string
-	Status    string
+	Status    HealthStatus
 	Roles     map[string]struct{}
 	TenantIDs map[string]struct{}
+
+	EnvironmentIDs                  map[string]struct{}
+	IsDisabled                      bool
+	StatusSummary                   string
+	Endpoint                        Endpoint
+	HasLatestCalamari               bool
+	TenantedDeploymentParticipation string
+	LastHealthCheck                 time.Time
 }
 
+// Endpoint is how Octopus reaches a machine, e.g. a "TentacleActive"
+// (polling) Tentacle at "poll://abc123/".
+type Endpoint struct {
+	CommunicationStyle string
+	URI                string
+}
+
+type machineJSON struct {
+	ID                              string     `json:"Id"`
+	Name                            string     `json:"Name"`
+	HealthStatus                    string     `json:"HealthStatus"`
+	Roles                           []string   `json:"Roles"`
+	TenantIDs                       []string   `json:"TenantIds"`
+	EnvironmentIDs                  []string   `json:"EnvironmentIds"`
+	IsDisabled                      bool       `json:"IsDisabled"`
+	StatusSummary                   string     `json:"StatusSummary"`
+	HasLatestCalamari               bool       `json:"HasLatestCalamari"`
+	TenantedDeploymentParticipation string     `json:"TenantedDeploymentParticipation"`
+	HealthLastChecked               *time.Time `json:"HealthLastChecked"`
+	Endpoint                        *struct {
+		CommunicationStyle string `json:"CommunicationStyle"`
+		URI                string `json:"Uri"`
+	} `json:"Endpoint"`
+}
+
+// UnmarshalJSON decodes a machine resource. Missing or null fields are left
+// empty; fields of the wrong type, or an unknown HealthStatus, are an error
+// naming the machine.
 func (m *Machine) UnmarshalJSON(data []byte) error {
-	var v map[string]interface{}
+	var v machineJSON
 	if err := json.Unmarshal(data, &v); err != nil {
-		return err
+		return fmt.Errorf("machine %s: %v", recordID(data, "Id"), err)
 	}
 
-	m.ID = v["Id"].(string)
-	m.Name = v["Name"].(string)
-	m.Status = v["HealthStatus"].(string)
-	m.Roles = make(map[string]struct{})
-	m.TenantIDs = make(map[string]struct{})
+	if v.ID == "" {
+		return fmt.Errorf("machine without an Id")
+	}
+
+	status, err := ParseHealthStatus(v.HealthStatus)
+	if err != nil {
+		return fmt.Errorf("machine %s: %v", v.ID, err)
+	}
 
-	for _, k := range v["Roles"].([]interface{}) {
-		m.Roles[k.(string)] = struct{}{}
+	m.ID = v.ID
+	m.Name = v.Name
+	m.Status = status
+	m.Roles = toSet(v.Roles)
+	m.TenantIDs = toSet(v.TenantIDs)
+	m.EnvironmentIDs = toSet(v.EnvironmentIDs)
+	m.IsDisabled = v.IsDisabled
+	m.StatusSummary = v.StatusSummary
+	m.HasLatestCalamari = v.HasLatestCalamari
+	m.TenantedDeploymentParticipation = v.TenantedDeploymentParticipation
+
+	if v.Endpoint != nil {
+		m.Endpoint = Endpoint{CommunicationStyle: v.Endpoint.CommunicationStyle, URI: v.Endpoint.URI}
 	}
 
-	for _, k := range v["TenantIds"].([]interface{}) {
-		m.TenantIDs[k.(string)] = struct{}{}
+	if v.HealthLastChecked != nil {
+		m.LastHealthCheck = *v.HealthLastChecked
 	}
 
 	return nil
@@ -48,78 +148,109 @@ type Tenant struct {
 	Variables  map[string]string
 }
 
+// tenantJSON covers both the tenant resource and the tenantvariables
+// resource, which name the same things differently.
+type tenantJSON struct {
+	ID                  string                     `json:"Id"`
+	TenantID            string                     `json:"TenantId"`
+	Name                string                     `json:"Name"`
+	TenantName          string                     `json:"TenantName"`
+	ProjectEnvironments map[string]json.RawMessage `json:"ProjectEnvironments"`
+	ProjectVariables    map[string]json.RawMessage `json:"ProjectVariables"`
+	LibraryVariables    map[string]struct {
+		Templates []struct {
+			ID   string `json:"Id"`
+			Name string `json:"Name"`
+		} `json:"Templates"`
+		Variables map[string]json.RawMessage `json:"Variables"`
+	} `json:"LibraryVariables"`
+}
+
+// UnmarshalJSON decodes either a tenant or a tenantvariables resource. Only
+// library variables with plain string values are kept (sensitive values, for
+// example, are objects). Fields of the wrong type are an error naming the
+// tenant.
 func (t *Tenant) UnmarshalJSON(data []byte) error {
-	var v map[string]interface{}
+	var v tenantJSON
 	if err := json.Unmarshal(data, &v); err != nil {
-		return err
+		return fmt.Errorf("tenant %s: %v", recordID(data, "TenantId", "Id"), err)
 	}
 
-	if id, ok := v["TenantId"]; ok {
-		t.ID = id.(string)
-	} else {
-		t.ID = v["Id"].(string)
+	t.ID = v.TenantID
+	if t.ID == "" {
+		t.ID = v.ID
 	}
 
-	if name, ok := v["TenantName"]; ok {
-		t.Name = name.(string)
-	} else {
-		t.Name = v["Name"].(string)
+	if t.ID == "" {
+		return fmt.Errorf("tenant without an Id")
 	}
 
-	t.ProjectIDs = make(map[string]struct{})
-
-	var projects map[string]interface{}
+	t.Name = v.TenantName
+	if t.Name == "" {
+		t.Name = v.Name
+	}
 
-	if _, ok := v["ProjectEnvironments"]; ok {
-		projects = v["ProjectEnvironments"].(map[string]interface{})
-	} else {
-		projects = v["ProjectVariables"].(map[string]interface{})
+	projects := v.ProjectEnvironments
+	if projects == nil {
+		projects = v.ProjectVariables
 	}
 
+	t.ProjectIDs = make(map[string]struct{}, len(projects))
+
 	for k := range projects {
 		t.ProjectIDs[k] = struct{}{}
 	}
 
 	t.Variables = make(map[string]string)
 
-	if libraries, ok := v["LibraryVariables"]; ok {
-		libraries := libraries.(map[string]interface{})
+	for _, variableSet := range v.LibraryVariables {
+		names := make(map[string]string, len(variableSet.Templates))
 
-		for _, variableSet := range libraries {
-			variableSet := variableSet.(map[string]interface{})
+		for _, template := range variableSet.Templates {
+			names[template.ID] = template.Name
+		}
 
-			if templates, ok := variableSet["Templates"]; ok {
-				templates := templates.([]interface{})
+		for id, raw := range variableSet.Variables {
+			var value string
 
-				for _, template := range templates {
-					template := template.(map[string]interface{})
+			if err := json.Unmarshal(raw, &value); err != nil {
+				continue
+			}
 
-					varName := template["Name"].(string)
-					varID := template["Id"].(string)
-					t.Variables[varID] = varName
-				}
+			if name, ok := names[id]; ok {
+				t.Variables[name] = value
 			}
+		}
+	}
 
-			if variables, ok := variableSet["Variables"]; ok {
-				variables := variables.(map[string]interface{})
+	return nil
+}
 
-				for id, v := range variables {
-					varName := t.Variables[id]
+func toSet(items []string) map[string]struct{} {
+	set := make(map[string]struct{}, len(items))
 
-					switch varValue := v.(type) {
-					case string:
-						t.Variables[varName] = varValue
-					default:
-						continue
-					}
+	for _, item := range items {
+		set[item] = struct{}{}
+	}
 
-					delete(t.Variables, id)
-				}
-			}
+	return set
+}
+
+// recordID returns the first of the given fields that is a string in data,
+// for naming a record that otherwise failed to decode.
+func recordID(data []byte, fields ...string) string {
+	var v map[string]interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return "(invalid JSON)"
+	}
+
+	for _, field := range fields {
+		if id, ok := v[field].(string); ok && id != "" {
+			return id
 		}
 	}
 
-	return nil
+	return "(unknown Id)"
 }
 
 type Event struct {
@@ -129,16 +260,24 @@ type Event struct {
 }
 
 type client interface {
-	FetchMachines() ([]Machine, error)
-	FetchMachine(machineID string) (Machine, error)
+	FetchMachines(ctx context.Context) ([]Machine, error)
+	FetchMachine(ctx context.Context, machineID string) (Machine, error)
+
+	FetchProjects(ctx context.Context) ([]Project, error)
+	FetchProject(ctx context.Context, projectID string) (Project, error)
+
+	FetchTenants(ctx context.Context) ([]Tenant, error)
+	FetchTenant(ctx context.Context, tenantID string) (Tenant, error)
 
-	FetchProjects() ([]Project, error)
-	FetchProject(projectID string) (Project, error)
+	FetchEvents(ctx context.Context, filter map[string]string, limit int) ([]Event, error)
 
-	FetchTenants() ([]Tenant, error)
-	FetchTenant(tenantID string) (Tenant, error)
+	FetchReleases(ctx context.Context, projectID string, limit int) ([]Release, error)
+	FetchDeployments(ctx context.Context, filter map[string]string, limit int) ([]Deployment, error)
+	FetchDashboard(ctx context.Context, projectIDs []string) ([]DashboardItem, error)
 
-	FetchEvents(filter map[string]string) ([]Event, error)
+	CreateHealthCheckTask(ctx context.Context, machineIDs []string) (Task, error)
+	FetchTask(ctx context.Context, taskID string) (Task, error)
+	SetMachineDisabled(ctx context.Context, machineID string, disabled bool) (Machine, error)
 }
 
 type Service struct {
@@ -151,30 +290,32 @@ func New(client client) Service {
 	}
 }
 
-func (s Service) FetchMachines() ([]Machine, error) {
-	return s.client.FetchMachines()
+func (s Service) FetchMachines(ctx context.Context) ([]Machine, error) {
+	return s.client.FetchMachines(ctx)
 }
 
-func (s Service) FetchMachine(machineID string) (Machine, error) {
-	return s.client.FetchMachine(machineID)
+func (s Service) FetchMachine(ctx context.Context, machineID string) (Machine, error) {
+	return s.client.FetchMachine(ctx, machineID)
 }
 
-func (s Service) FetchProjects() ([]Project, error) {
-	return s.client.FetchProjects()
+func (s Service) FetchProjects(ctx context.Context) ([]Project, error) {
+	return s.client.FetchProjects(ctx)
 }
 
-func (s Service) FetchProject(projectID string) (Project, error) {
-	return s.client.FetchProject(projectID)
+func (s Service) FetchProject(ctx context.Context, projectID string) (Project, error) {
+	return s.client.FetchProject(ctx, projectID)
 }
 
-func (s Service) FetchTenants() ([]Tenant, error) {
-	return s.client.FetchTenants()
+func (s Service) FetchTenants(ctx context.Context) ([]Tenant, error) {
+	return s.client.FetchTenants(ctx)
 }
 
-func (s Service) FetchTenant(tenantID string) (Tenant, error) {
-	return s.client.FetchTenant(tenantID)
+func (s Service) FetchTenant(ctx context.Context, tenantID string) (Tenant, error) {
+	return s.client.FetchTenant(ctx, tenantID)
 }
 
-func (s Service) FetchEvents(filter map[string]string) ([]Event, error) {
-	return s.client.FetchEvents(filter)
+// FetchEvents returns every event matching filter, or at most limit events if
+// limit is positive.
+func (s Service) FetchEvents(ctx context.Context, filter map[string]string, limit int) ([]Event, error) {
+	return s.client.FetchEvents(ctx, filter, limit)
 }
@@ -0,0 +1,73 @@
+package octopus
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TaskState is where a server task is in its lifecycle.
+type TaskState string
+
+const (
+	TaskQueued     TaskState = "Queued"
+	TaskExecuting  TaskState = "Executing"
+	TaskCancelling TaskState = "Cancelling"
+	TaskSuccess    TaskState = "Success"
+	TaskFailed     TaskState = "Failed"
+	TaskCanceled   TaskState = "Canceled"
+	TaskTimedOut   TaskState = "TimedOut"
+)
+
+// Task is an Octopus server task, such as a health check.
+type Task struct {
+	ID                   string `json:"Id"`
+	Name                 string
+	Description          string
+	State                TaskState
+	IsCompleted          bool
+	FinishedSuccessfully bool
+	ErrorMessage         string
+	QueueTime            time.Time
+	CompletedTime        *time.Time
+}
+
+// CreateHealthCheckTask queues a health check of the given machines.
+func (s Service) CreateHealthCheckTask(ctx context.Context, machineIDs []string) (Task, error) {
+	return s.client.CreateHealthCheckTask(ctx, machineIDs)
+}
+
+func (s Service) FetchTask(ctx context.Context, taskID string) (Task, error) {
+	return s.client.FetchTask(ctx, taskID)
+}
+
+// WaitForTask polls a task every interval until it completes, returning its
+// final state, or an error if ctx is done first.
+func (s Service) WaitForTask(ctx context.Context, taskID string, interval time.Duration) (Task, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		task, err := s.client.FetchTask(ctx, taskID)
+
+		if err != nil {
+			return task, err
+		}
+
+		if task.IsCompleted {
+			return task, nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return task, fmt.Errorf("task %s still %s: %v", taskID, task.State, ctx.Err())
+		}
+	}
+}
+
+// SetMachineDisabled disables or re-enables a machine, returning it as
+// updated.
+func (s Service) SetMachineDisabled(ctx context.Context, machineID string, disabled bool) (Machine, error) {
+	return s.client.SetMachineDisabled(ctx, machineID, disabled)
+}
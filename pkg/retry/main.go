@@ -0,0 +1,263 @@
+// Package retry provides an HTTP client wrapper that retries requests which
+// fail for transient reasons: dropped connections, 5xx responses, and 429
+// rate limiting.
+package retry
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultMaxAttempts = 4
+	defaultBaseDelay   = 500 * time.Millisecond
+	defaultMaxDelay    = 30 * time.Second
+)
+
+type httpDoer interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Error is returned when a request still fails after every attempt.
+type Error struct {
+	Method   string
+	URL      string
+	Attempts int
+	Err      error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s failed after %d attempt(s): %v", e.Method, e.URL, e.Attempts, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Stats counts the work done by a Doer over its lifetime. Failures are
+// requests that ended in an error, or in a 5xx or 429 response, after their
+// last attempt.
+type Stats struct {
+	Requests int64
+	Attempts int64
+	Failures int64
+}
+
+// Doer wraps another httpDoer (usually an *http.Client) and retries requests
+// with exponential backoff and jitter. Only GET, HEAD and OPTIONS requests
+// are retried unless Methods says otherwise, or the request's context was
+// made by Idempotent. Zero values use the defaults.
+type Doer struct {
+	Client      httpDoer
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+
+	// Methods lists the HTTP methods that are safe to retry.
+	Methods []string
+
+	// OnRetry, if set, is called before sleeping ahead of another attempt.
+	// Exactly one of resp and err is non-nil.
+	OnRetry func(req *http.Request, attempt int, resp *http.Response, err error, delay time.Duration)
+
+	requests int64
+	attempts int64
+	failures int64
+}
+
+type idempotentKey struct{}
+
+// Idempotent returns a copy of ctx that marks requests made with it as safe
+// to retry whatever their method, e.g. a POST that only reads.
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// New returns a Doer wrapping client with the default settings.
+func New(client httpDoer) *Doer {
+	return &Doer{Client: client}
+}
+
+// Stats returns a snapshot of the Doer's counters.
+func (d *Doer) Stats() Stats {
+	return Stats{
+		Requests: atomic.LoadInt64(&d.requests),
+		Attempts: atomic.LoadInt64(&d.attempts),
+		Failures: atomic.LoadInt64(&d.failures),
+	}
+}
+
+// Do sends req, retrying transient failures. If the final attempt returns a
+// response, that response is returned as-is so the caller can inspect it.
+func (d *Doer) Do(req *http.Request) (*http.Response, error) {
+	atomic.AddInt64(&d.requests, 1)
+
+	maxAttempts := d.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = defaultMaxAttempts
+	}
+
+	if !d.retryable(req) {
+		maxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		atomic.AddInt64(&d.attempts, 1)
+
+		resp, err := d.Client.Do(req)
+
+		if !shouldRetry(req, resp, err) || attempt >= maxAttempts {
+			if err != nil {
+				atomic.AddInt64(&d.failures, 1)
+				return nil, &Error{Method: req.Method, URL: req.URL.String(), Attempts: attempt, Err: err}
+			}
+
+			if shouldRetry(req, resp, nil) {
+				atomic.AddInt64(&d.failures, 1)
+			}
+
+			return resp, nil
+		}
+
+		delay := d.backoff(attempt, resp)
+
+		if d.OnRetry != nil {
+			d.OnRetry(req, attempt, resp, err, delay)
+		}
+
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		if err := rewind(req); err != nil {
+			atomic.AddInt64(&d.failures, 1)
+			return nil, &Error{Method: req.Method, URL: req.URL.String(), Attempts: attempt, Err: err}
+		}
+
+		timer := time.NewTimer(delay)
+
+		select {
+		case <-timer.C:
+		case <-req.Context().Done():
+			timer.Stop()
+			atomic.AddInt64(&d.failures, 1)
+			return nil, &Error{Method: req.Method, URL: req.URL.String(), Attempts: attempt, Err: req.Context().Err()}
+		}
+	}
+}
+
+func (d *Doer) retryable(req *http.Request) bool {
+	methods := d.Methods
+	if methods == nil {
+		methods = []string{"GET", "HEAD", "OPTIONS"}
+	}
+
+	replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+
+	if req.Context().Value(idempotentKey{}) != nil {
+		return replayable
+	}
+
+	for _, m := range methods {
+		if m == req.Method {
+			return replayable
+		}
+	}
+
+	return false
+}
+
+func shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		// a cancelled or expired context is not a transient failure
+		return req.Context().Err() == nil
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return true
+	case resp.StatusCode >= 500 && resp.StatusCode != http.StatusNotImplemented:
+		return true
+	default:
+		return false
+	}
+}
+
+// backoff returns how long to wait before the next attempt: the response's
+// Retry-After if it has one, otherwise an exponentially growing delay with
+// jitter. Either way the result is capped at MaxDelay.
+func (d *Doer) backoff(attempt int, resp *http.Response) time.Duration {
+	base, max := d.BaseDelay, d.MaxDelay
+	if base <= 0 {
+		base = defaultBaseDelay
+	}
+	if max <= 0 {
+		max = defaultMaxDelay
+	}
+
+	if resp != nil {
+		if after, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			if after > max {
+				return max
+			}
+			return after
+		}
+	}
+
+	delay := base << uint(attempt-1)
+	if delay <= 0 || delay > max {
+		delay = max
+	}
+
+	// "equal jitter": somewhere between half and all of the computed delay
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// retryAfter parses a Retry-After header, which may be either a number of
+// seconds or an HTTP date.
+func retryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		after := time.Until(t)
+		if after < 0 {
+			after = 0
+		}
+		return after, true
+	}
+
+	return 0, false
+}
+
+func rewind(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+
+	if req.GetBody == nil {
+		return fmt.Errorf("request body cannot be replayed")
+	}
+
+	body, err := req.GetBody()
+	if err != nil {
+		return fmt.Errorf("error replaying request body: %v", err)
+	}
+
+	req.Body = body
+	return nil
+}
@@ -0,0 +1,234 @@
+package retry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// server responds with statuses in turn, repeating the last one, and records
+// the body of every request it receives.
+type server struct {
+	statuses   []int
+	retryAfter string
+
+	mu     sync.Mutex
+	bodies []string
+}
+
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	s.mu.Lock()
+	n := len(s.bodies)
+	s.bodies = append(s.bodies, string(body))
+	s.mu.Unlock()
+
+	status := s.statuses[len(s.statuses)-1]
+	if n < len(s.statuses) {
+		status = s.statuses[n]
+	}
+
+	if status == http.StatusTooManyRequests && s.retryAfter != "" {
+		w.Header().Set("Retry-After", s.retryAfter)
+	}
+
+	w.WriteHeader(status)
+}
+
+func (s *server) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.bodies)
+}
+
+func newDoer(client *http.Client) *Doer {
+	return &Doer{
+		Client:    client,
+		BaseDelay: time.Millisecond,
+		MaxDelay:  10 * time.Millisecond,
+	}
+}
+
+func TestDoRetriesServerErrors(t *testing.T) {
+	s := &server{statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusOK}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	d := newDoer(ts.Client())
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+
+	resp, err := d.Do(req)
+
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("got status %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+
+	if got := s.attempts(); got != 3 {
+		t.Errorf("got %d attempts, want 3", got)
+	}
+
+	want := Stats{Requests: 1, Attempts: 3, Failures: 0}
+	if got := d.Stats(); got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+}
+
+func TestDoHonoursRetryAfter(t *testing.T) {
+	s := &server{statuses: []int{http.StatusTooManyRequests, http.StatusOK}, retryAfter: "1"}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	var delays []time.Duration
+
+	d := newDoer(ts.Client())
+	d.MaxDelay = 5 * time.Second
+	d.OnRetry = func(req *http.Request, attempt int, resp *http.Response, err error, delay time.Duration) {
+		delays = append(delays, delay)
+	}
+
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+	start := time.Now()
+
+	resp, err := d.Do(req)
+
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(delays) != 1 || delays[0] != time.Second {
+		t.Errorf("got delays %v, want [1s]", delays)
+	}
+
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("retried after %s, want at least 1s", elapsed)
+	}
+}
+
+func TestDoStopsAtMaxAttempts(t *testing.T) {
+	s := &server{statuses: []int{http.StatusServiceUnavailable}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	d := newDoer(ts.Client())
+	d.MaxAttempts = 3
+	req, _ := http.NewRequest("GET", ts.URL, nil)
+
+	resp, err := d.Do(req)
+
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("got status %d, want the last response's %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+
+	if got := s.attempts(); got != 3 {
+		t.Errorf("got %d attempts, want 3", got)
+	}
+
+	want := Stats{Requests: 1, Attempts: 3, Failures: 1}
+	if got := d.Stats(); got != want {
+		t.Errorf("got stats %+v, want %+v", got, want)
+	}
+}
+
+func TestDoDoesNotRetryUnsafeMethods(t *testing.T) {
+	s := &server{statuses: []int{http.StatusServiceUnavailable}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	d := newDoer(ts.Client())
+	req, _ := http.NewRequest("POST", ts.URL, bytes.NewReader([]byte("{}")))
+
+	resp, err := d.Do(req)
+
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	resp.Body.Close()
+
+	if got := s.attempts(); got != 1 {
+		t.Errorf("got %d attempts, want 1", got)
+	}
+}
+
+func TestDoStopsWhenCancelledDuringBackoff(t *testing.T) {
+	s := &server{statuses: []int{http.StatusServiceUnavailable}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	d := newDoer(ts.Client())
+	d.BaseDelay = time.Hour
+	d.MaxDelay = time.Hour
+	d.OnRetry = func(req *http.Request, attempt int, resp *http.Response, err error, delay time.Duration) {
+		cancel()
+	}
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL, nil)
+
+	_, err := d.Do(req)
+
+	var retryErr *Error
+	if !errors.As(err, &retryErr) {
+		t.Fatalf("got error %v, want a *retry.Error", err)
+	}
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+
+	if retryErr.Attempts != 1 || s.attempts() != 1 {
+		t.Errorf("got %d attempts (server saw %d), want 1", retryErr.Attempts, s.attempts())
+	}
+
+	if got := d.Stats().Failures; got != 1 {
+		t.Errorf("got %d failures, want 1", got)
+	}
+}
+
+func TestDoReplaysBody(t *testing.T) {
+	s := &server{statuses: []int{http.StatusBadGateway, http.StatusOK}}
+	ts := httptest.NewServer(s)
+	defer ts.Close()
+
+	const body = `{"query":"hvr_latency"}`
+
+	d := newDoer(ts.Client())
+	req, _ := http.NewRequestWithContext(Idempotent(context.Background()), "POST", ts.URL, bytes.NewReader([]byte(body)))
+
+	resp, err := d.Do(req)
+
+	if err != nil {
+		t.Fatalf("Do returned error: %v", err)
+	}
+	resp.Body.Close()
+
+	if len(s.bodies) != 2 {
+		t.Fatalf("got %d attempts, want 2", len(s.bodies))
+	}
+
+	for i, got := range s.bodies {
+		if got != body {
+			t.Errorf("attempt %d sent body %q, want %q", i+1, got, body)
+		}
+	}
+}
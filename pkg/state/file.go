@@ -0,0 +1,60 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// File is a Store backed by a local JSON file.
+type File struct {
+	Path string
+}
+
+// Load reads the state file, returning an empty State if it doesn't exist.
+func (f File) Load() (State, error) {
+	var s State
+
+	data, err := ioutil.ReadFile(f.Path)
+
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return s, fmt.Errorf("error reading state file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("error decoding state file %s: %v", f.Path, err)
+	}
+
+	return s, nil
+}
+
+// Save writes the state file. It writes to a temporary file first, so that a
+// crash can't leave a half-written state behind.
+func (f File) Save(s State) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding state: %v", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return fmt.Errorf("error creating state directory: %v", err)
+	}
+
+	tmp := f.Path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("error writing state file: %v", err)
+	}
+
+	if err := os.Rename(tmp, f.Path); err != nil {
+		return fmt.Errorf("error replacing state file: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,166 @@
+// Package state records CDC findings between runs, so that a report can say
+// which problems are new, which are ongoing, and which have recovered.
+package state
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/michaelmosher/monitoring/pkg/cdc"
+)
+
+// Status describes how a finding relates to the previous run.
+type Status string
+
+const (
+	StatusNew       Status = "new"
+	StatusOngoing   Status = "ongoing"
+	StatusRecovered Status = "recovered"
+	// StatusEscalated and StatusDeescalated are ongoing findings whose
+	// severity has risen (to critical) or fallen (to warning).
+	StatusEscalated   Status = "escalated"
+	StatusDeescalated Status = "de-escalated"
+)
+
+// Entry is a finding that has been seen in at least one run.
+type Entry struct {
+	Instance  string       `json:"instance"`
+	Check     cdc.Check    `json:"check"`
+	Tenant    string       `json:"tenant"`
+	Severity  cdc.Severity `json:"severity"`
+	FirstSeen time.Time    `json:"firstSeen"`
+	LastSeen  time.Time    `json:"lastSeen"`
+}
+
+func (e Entry) key() string {
+	return fmt.Sprintf("%s\x00%s\x00%s", e.Instance, e.Check, e.Tenant)
+}
+
+// Change is an Entry and its Status in the latest run.
+type Change struct {
+	Entry
+	Status Status `json:"status"`
+}
+
+// State is every finding that was unhealthy as of the last run.
+type State struct {
+	Entries []Entry `json:"entries"`
+}
+
+// Store loads and saves State. Load returns an empty State if nothing has
+// been saved yet.
+type Store interface {
+	Load() (State, error)
+	Save(State) error
+}
+
+// Update compares report with the previous state, returning the new state and
+// a Change for every finding that is new, ongoing or recovered.
+//
+// A finding is only "recovered" if its check ran successfully for that
+// tenant; a check that failed outright, or failed for that tenant, carries
+// the previous entries forward unchanged.
+func Update(previous State, report cdc.Report) (State, []Change) {
+	known := make(map[string]Entry, len(previous.Entries))
+
+	for _, e := range previous.Entries {
+		known[e.key()] = e
+	}
+
+	var next State
+	var changes []Change
+	seen := make(map[string]bool)
+
+	for _, cr := range report.Results {
+		for _, f := range cr.Findings {
+			e := Entry{
+				Instance:  cr.Label,
+				Check:     cr.Check,
+				Tenant:    f.Tenant,
+				Severity:  f.Severity,
+				FirstSeen: report.GeneratedAt,
+				LastSeen:  report.GeneratedAt,
+			}
+
+			status := StatusNew
+
+			if old, ok := known[e.key()]; ok {
+				e.FirstSeen = old.FirstSeen
+				status = severityChange(old.Severity, e.Severity)
+			}
+
+			seen[e.key()] = true
+			next.Entries = append(next.Entries, e)
+			changes = append(changes, Change{Entry: e, Status: status})
+		}
+	}
+
+	for _, e := range previous.Entries {
+		if seen[e.key()] {
+			continue
+		}
+
+		if unknown(report, e) {
+			next.Entries = append(next.Entries, e)
+			continue
+		}
+
+		changes = append(changes, Change{Entry: e, Status: StatusRecovered})
+	}
+
+	sort.Slice(next.Entries, func(i, j int) bool {
+		return next.Entries[i].key() < next.Entries[j].key()
+	})
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].key() < changes[j].key()
+	})
+
+	return next, changes
+}
+
+// unknown reports whether report says nothing about e: either e's check
+// didn't run, failed outright, or failed for e's tenant.
+func unknown(report cdc.Report, e Entry) bool {
+	for _, cr := range report.Results {
+		if cr.Label != e.Instance || cr.Check != e.Check {
+			continue
+		}
+
+		for _, err := range cr.Errors {
+			if err.Tenant == "" || err.Tenant == e.Tenant {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	return true
+}
+
+// severityChange returns the Status of an ongoing finding whose severity was
+// previously old.
+func severityChange(old cdc.Severity, current cdc.Severity) Status {
+	switch {
+	case old == current:
+		return StatusOngoing
+	case current == cdc.SeverityCritical:
+		return StatusEscalated
+	default:
+		return StatusDeescalated
+	}
+}
+
+// Transitioned reports whether any change is new, recovered, or has changed
+// severity.
+func Transitioned(changes []Change) bool {
+	for _, c := range changes {
+		if c.Status != StatusOngoing {
+			return true
+		}
+	}
+
+	return false
+}